@@ -0,0 +1,46 @@
+package xmlquery
+
+const defaultArenaChunkSize = 1024
+
+// A NodeArena allocates nodes in chunks, to reduce the number of
+// allocations and the GC overhead of parsing large documents. Set
+// ParserOptions.Arena to parse a document into an arena.
+//
+// A chunk is released only when none of its nodes is referenced anymore, so
+// keeping a single node of a parsed document keeps its neighbours in memory,
+// and the nodes removed by a StreamParser aren't freed until the whole chunk
+// is unused. An arena may be shared by several parses to batch their nodes,
+// but it must not be used concurrently.
+//
+// The zero value is ready to use, with chunks of 1024 nodes.
+type NodeArena struct {
+	chunk     []Node
+	chunkSize int
+	count     int
+}
+
+// NewNodeArena returns an arena whose chunks hold chunkSize nodes. A
+// chunkSize less than or equal to zero selects the default size.
+func NewNodeArena(chunkSize int) *NodeArena {
+	return &NodeArena{chunkSize: chunkSize}
+}
+
+// Len returns the number of nodes allocated from the arena.
+func (a *NodeArena) Len() int {
+	return a.count
+}
+
+func (a *NodeArena) alloc() *Node {
+	if len(a.chunk) == cap(a.chunk) {
+		size := a.chunkSize
+		if size <= 0 {
+			size = defaultArenaChunkSize
+		}
+		// A new chunk is allocated rather than growing the current one, so
+		// the nodes never move.
+		a.chunk = make([]Node, 0, size)
+	}
+	a.chunk = a.chunk[:len(a.chunk)+1]
+	a.count++
+	return &a.chunk[len(a.chunk)-1]
+}
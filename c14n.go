@@ -0,0 +1,245 @@
+package xmlquery
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const xmlNamespaceURI = "http://www.w3.org/XML/1998/namespace"
+
+var c14nTextEscaper = strings.NewReplacer(
+	`&`, "&amp;",
+	`<`, "&lt;",
+	`>`, "&gt;",
+	"\r", "&#xD;",
+)
+
+var c14nAttrEscaper = strings.NewReplacer(
+	`&`, "&amp;",
+	`<`, "&lt;",
+	`"`, "&quot;",
+	"\t", "&#x9;",
+	"\n", "&#xA;",
+	"\r", "&#xD;",
+)
+
+// CanonicalSubtree returns the canonical form, without comments, of the
+// element n and its descendants taken in isolation from their document, as
+// used to sign a referenced element with XML-DSig.
+//
+// If exclusive is false, it's the Canonical XML 1.0 form: the namespaces
+// and the xml:* attributes, such as xml:lang, inherited from the ancestors
+// of n are written on n. Otherwise it's the Exclusive XML Canonicalization
+// 1.0 form: a namespace is only declared on the elements that use it in
+// their name or in the name of one of their attributes, except for the
+// prefixes listed in inclusiveNamespaces, where "#default" stands for the
+// default namespace, which are handled as in the inclusive form.
+//
+// An error is returned if n is not an element or if a prefix used in the
+// subtree is not declared.
+func (n *Node) CanonicalSubtree(exclusive bool, inclusiveNamespaces []string) (string, error) {
+	if n.Type != ElementNode {
+		return "", fmt.Errorf("xmlquery: CanonicalSubtree requires an element node")
+	}
+	c := &canonicalizer{exclusive: exclusive, inclusive: make(map[string]bool)}
+	for _, prefix := range inclusiveNamespaces {
+		if prefix == "#default" {
+			prefix = ""
+		}
+		c.inclusive[prefix] = true
+	}
+	scope := map[string]string{"": ""}
+	var ancestors []*Node
+	for a := n.Parent; a != nil; a = a.Parent {
+		ancestors = append(ancestors, a)
+	}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		scope = declaredNamespaces(ancestors[i], scope)
+	}
+	var inherited []Attr
+	if !exclusive {
+		inherited = inheritedXMLAttrs(n)
+	}
+	if err := c.writeElement(n, scope, map[string]string{"": ""}, inherited); err != nil {
+		return "", err
+	}
+	return c.b.String(), nil
+}
+
+type canonicalizer struct {
+	b         strings.Builder
+	exclusive bool
+	inclusive map[string]bool // prefixes of InclusiveNamespaces, with "" for the default namespace
+}
+
+// declaredNamespaces returns scope updated with the namespace declarations
+// of n, as a map from prefix to namespace URI. scope is not modified.
+func declaredNamespaces(n *Node, scope map[string]string) map[string]string {
+	var updated map[string]string
+	for _, attr := range n.Attr {
+		prefix, ok := namespaceDeclPrefix(attr)
+		if !ok {
+			continue
+		}
+		if updated == nil {
+			updated = make(map[string]string, len(scope)+1)
+			for k, v := range scope {
+				updated[k] = v
+			}
+		}
+		updated[prefix] = attr.Value
+	}
+	if updated == nil {
+		return scope
+	}
+	return updated
+}
+
+// namespaceDeclPrefix returns the prefix declared by attr, "" for the
+// default namespace, if attr is a namespace declaration.
+func namespaceDeclPrefix(attr Attr) (string, bool) {
+	switch {
+	case attr.Name.Space == "xmlns":
+		return attr.Name.Local, true
+	case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+		return "", true
+	}
+	return "", false
+}
+
+// inheritedXMLAttrs returns the xml:* attributes of the ancestors of n that
+// are in effect on n but not specified by n, nearest ancestor first.
+func inheritedXMLAttrs(n *Node) []Attr {
+	seen := make(map[string]bool)
+	for _, attr := range n.Attr {
+		if attr.Name.Space == "xml" {
+			seen[attr.Name.Local] = true
+		}
+	}
+	var list []Attr
+	for a := n.Parent; a != nil; a = a.Parent {
+		for _, attr := range a.Attr {
+			if attr.Name.Space == "xml" && !seen[attr.Name.Local] {
+				seen[attr.Name.Local] = true
+				list = append(list, attr)
+			}
+		}
+	}
+	return list
+}
+
+// writeElement writes the element n. scope are the namespaces in scope of
+// the parent of n, and rendered the namespaces declared by the nearest
+// ancestors written.
+func (c *canonicalizer) writeElement(n *Node, scope, rendered map[string]string, extra []Attr) error {
+	scope = declaredNamespaces(n, scope)
+	if _, ok := scope[n.Prefix]; !ok && n.Prefix != "" && n.Prefix != "xml" {
+		return fmt.Errorf("xmlquery: namespace prefix %s of element <%s> is not declared", n.Prefix, n.Data)
+	}
+
+	var attrs []Attr
+	for _, attr := range append(n.Attr[:len(n.Attr):len(n.Attr)], extra...) {
+		if _, ok := namespaceDeclPrefix(attr); ok {
+			continue
+		}
+		switch prefix := attr.Name.Space; {
+		case prefix == "xml":
+			attr.NamespaceURI = xmlNamespaceURI
+		case prefix != "":
+			uri, ok := scope[prefix]
+			if !ok {
+				return fmt.Errorf("xmlquery: namespace prefix %s of attribute %s is not declared", prefix, attr.Name.Local)
+			}
+			attr.NamespaceURI = uri
+		default:
+			attr.NamespaceURI = ""
+		}
+		attrs = append(attrs, attr)
+	}
+	sort.SliceStable(attrs, func(i, j int) bool {
+		if attrs[i].NamespaceURI != attrs[j].NamespaceURI {
+			return attrs[i].NamespaceURI < attrs[j].NamespaceURI
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+
+	// the namespace declarations to write
+	candidates := make(map[string]bool)
+	if c.exclusive {
+		candidates[n.Prefix] = true
+		for _, attr := range attrs {
+			if attr.Name.Space != "" {
+				candidates[attr.Name.Space] = true
+			}
+		}
+		for prefix := range c.inclusive {
+			if _, ok := scope[prefix]; ok {
+				candidates[prefix] = true
+			}
+		}
+	} else {
+		for prefix := range scope {
+			candidates[prefix] = true
+		}
+	}
+	var prefixes []string
+	for prefix := range candidates {
+		if prefix == "xml" {
+			continue
+		}
+		uri, inScope := scope[prefix]
+		if prev, ok := rendered[prefix]; ok && prev == uri || !inScope {
+			continue
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	if len(prefixes) > 0 {
+		updated := make(map[string]string, len(rendered)+len(prefixes))
+		for k, v := range rendered {
+			updated[k] = v
+		}
+		for _, prefix := range prefixes {
+			updated[prefix] = scope[prefix]
+		}
+		rendered = updated
+	}
+
+	c.b.WriteString("<" + n.qualifiedName())
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			c.b.WriteString(` xmlns="`)
+		} else {
+			c.b.WriteString(` xmlns:` + prefix + `="`)
+		}
+		c.b.WriteString(c14nAttrEscaper.Replace(scope[prefix]) + `"`)
+	}
+	for _, attr := range attrs {
+		name := attr.Name.Local
+		if attr.Name.Space != "" {
+			name = attr.Name.Space + ":" + name
+		}
+		c.b.WriteString(" " + name + `="` + c14nAttrEscaper.Replace(attr.Value) + `"`)
+	}
+	c.b.WriteString(">")
+
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case ElementNode:
+			if err := c.writeElement(child, scope, rendered, nil); err != nil {
+				return err
+			}
+		case TextNode, CharDataNode:
+			c.b.WriteString(c14nTextEscaper.Replace(child.Data))
+		case DeclarationNode:
+			c.b.WriteString("<?" + child.Data)
+			for _, attr := range child.Attr {
+				c.b.WriteString(" " + attr.Name.Local + `="` + attr.Value + `"`)
+			}
+			c.b.WriteString("?>")
+		}
+	}
+	c.b.WriteString("</" + n.qualifiedName() + ">")
+	return nil
+}
@@ -0,0 +1,84 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCanonicalSubtree(t *testing.T) {
+	s := `<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org" xml:space="preserve">
+  <n1:elem2 xmlns:n1="http://example.net" xml:lang="en">
+    <n3:stuff xmlns:n3="ftp://example.org"/>
+  </n1:elem2>
+</n0:local>`
+	doc, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	elem2 := FindOne(doc, "//*[local-name()='elem2']")
+
+	got, err := elem2.CanonicalSubtree(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<n1:elem2 xmlns:n0="foo:bar" xmlns:n1="http://example.net" xmlns:n3="ftp://example.org" xml:lang="en" xml:space="preserve">
+    <n3:stuff></n3:stuff>
+  </n1:elem2>`
+	testValue(t, got, want)
+
+	got, err = elem2.CanonicalSubtree(true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en">
+    <n3:stuff xmlns:n3="ftp://example.org"></n3:stuff>
+  </n1:elem2>`
+	testValue(t, got, want)
+
+	got, err = elem2.CanonicalSubtree(true, []string{"n3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `<n1:elem2 xmlns:n1="http://example.net" xmlns:n3="ftp://example.org" xml:lang="en">
+    <n3:stuff></n3:stuff>
+  </n1:elem2>`
+	testValue(t, got, want)
+}
+
+func TestCanonicalSubtreeDefaultNamespace(t *testing.T) {
+	s := `<root xmlns="urn:a"><a:x xmlns:a="urn:b" z="2" a:y="1" b="&quot;&#9;"><inner xmlns="">t &amp; &lt;&gt;</inner></a:x></root>`
+	doc, err := Parse(strings.NewReader(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x := FindOne(doc, "//*[local-name()='x']")
+
+	got, err := x.CanonicalSubtree(false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testValue(t, got, `<a:x xmlns="urn:a" xmlns:a="urn:b" b="&quot;&#x9;" z="2" a:y="1"><inner xmlns="">t &amp; &lt;&gt;</inner></a:x>`)
+
+	got, err = x.CanonicalSubtree(true, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testValue(t, got, `<a:x xmlns:a="urn:b" b="&quot;&#x9;" z="2" a:y="1"><inner>t &amp; &lt;&gt;</inner></a:x>`)
+
+	got, err = x.CanonicalSubtree(true, []string{"#default"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testValue(t, got, `<a:x xmlns="urn:a" xmlns:a="urn:b" b="&quot;&#x9;" z="2" a:y="1"><inner xmlns="">t &amp; &lt;&gt;</inner></a:x>`)
+}
+
+func TestCanonicalSubtreeErrors(t *testing.T) {
+	doc := &Node{Type: DocumentNode}
+	if _, err := doc.CanonicalSubtree(false, nil); err == nil {
+		t.Fatal("expected an error for a document node")
+	}
+	n := &Node{Type: ElementNode, Data: "x", Prefix: "p"}
+	if _, err := n.CanonicalSubtree(true, nil); err == nil {
+		t.Fatal("expected an error for an undeclared prefix")
+	}
+}
@@ -24,7 +24,11 @@ var (
 func getQuery(expr string, opts xpath.CompileOptions) (*xpath.Expr, error) {
 	key := expr + fmt.Sprintf("%#v", opts)
 	if DisableSelectorCache || SelectorCacheMaxEntries <= 0 {
-		return xpath.CompileWithOptions(expr, opts)
+		v, err := xpath.CompileWithOptions(expr, opts)
+		if err != nil {
+			return nil, &QueryError{Expr: expr, Err: err}
+		}
+		return v, nil
 	}
 	cacheOnce.Do(func() {
 		cache = lru.New(SelectorCacheMaxEntries)
@@ -36,7 +40,7 @@ func getQuery(expr string, opts xpath.CompileOptions) (*xpath.Expr, error) {
 	}
 	v, err := xpath.CompileWithOptions(expr, opts)
 	if err != nil {
-		return nil, err
+		return nil, &QueryError{Expr: expr, Err: err}
 	}
 	cache.Add(key, v)
 	return v, nil
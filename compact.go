@@ -0,0 +1,431 @@
+package xmlquery
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/antchfx/xpath"
+)
+
+// A CompactDocument is an immutable, read-optimized copy of a tree. Its
+// nodes are stored in a single slice in document order and refer to each
+// other by index, which improves memory locality when traversing or
+// querying large documents. Nodes are accessed through CompactNode values
+// and can be queried with the same XPath expressions as a Node tree.
+type CompactDocument struct {
+	nodes []compactNode
+	attrs []Attr
+}
+
+const noNode = -1
+
+type compactNode struct {
+	typ          NodeType
+	data         string
+	prefix       string
+	namespaceURI string
+	level        int32
+	parent       int32
+	firstChild   int32
+	lastChild    int32
+	prevSibling  int32
+	nextSibling  int32
+	end          int32 // index following the last descendant
+	attrStart    int32
+	attrEnd      int32
+}
+
+// ParseCompact parses the XML from the given Reader into a CompactDocument.
+func ParseCompact(r io.Reader) (*CompactDocument, error) {
+	doc, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	return NewCompactDocument(doc), nil
+}
+
+// NewCompactDocument returns a CompactDocument holding a copy of the tree
+// rooted at n. Later changes of the tree are not reflected in the copy.
+func NewCompactDocument(n *Node) *CompactDocument {
+	d := &CompactDocument{}
+	d.add(n, noNode, noNode)
+	return d
+}
+
+func (d *CompactDocument) add(n *Node, parent, prev int32) int32 {
+	i := int32(len(d.nodes))
+	d.nodes = append(d.nodes, compactNode{
+		typ:          n.Type,
+		data:         n.Data,
+		prefix:       n.Prefix,
+		namespaceURI: n.NamespaceURI,
+		level:        int32(n.level),
+		parent:       parent,
+		firstChild:   noNode,
+		lastChild:    noNode,
+		prevSibling:  prev,
+		nextSibling:  noNode,
+		attrStart:    int32(len(d.attrs)),
+	})
+	d.attrs = append(d.attrs, n.Attr...)
+	d.nodes[i].attrEnd = int32(len(d.attrs))
+	last := int32(noNode)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		c := d.add(child, i, last)
+		if last == noNode {
+			d.nodes[i].firstChild = c
+		} else {
+			d.nodes[last].nextSibling = c
+		}
+		last = c
+	}
+	d.nodes[i].lastChild = last
+	d.nodes[i].end = int32(len(d.nodes))
+	return i
+}
+
+// Root returns the root node of the document.
+func (d *CompactDocument) Root() CompactNode {
+	return CompactNode{doc: d, i: 0, attr: noNode}
+}
+
+// Len returns the number of nodes of the document.
+func (d *CompactDocument) Len() int {
+	return len(d.nodes)
+}
+
+// A CompactNode is a node of a CompactDocument. The zero value is not a
+// valid node; the navigation methods return it when there is no such node.
+type CompactNode struct {
+	doc  *CompactDocument
+	i    int32
+	attr int32 // index of the attribute in the element, if it's an attribute node
+}
+
+func (c CompactNode) node(i int32) CompactNode {
+	if i == noNode {
+		return CompactNode{}
+	}
+	return CompactNode{doc: c.doc, i: i, attr: noNode}
+}
+
+// Valid reports whether c is a node of a document.
+func (c CompactNode) Valid() bool {
+	return c.doc != nil
+}
+
+// Type returns the type of the node.
+func (c CompactNode) Type() NodeType {
+	if c.attr != noNode {
+		return AttributeNode
+	}
+	return c.doc.nodes[c.i].typ
+}
+
+// Data returns the name of an element or attribute node, or the content
+// of other nodes, as Node.Data.
+func (c CompactNode) Data() string {
+	if c.attr != noNode {
+		return c.doc.attrs[c.doc.nodes[c.i].attrStart+c.attr].Name.Local
+	}
+	return c.doc.nodes[c.i].data
+}
+
+// Prefix returns the namespace prefix of the node.
+func (c CompactNode) Prefix() string {
+	if c.attr != noNode {
+		return c.doc.attrs[c.doc.nodes[c.i].attrStart+c.attr].Name.Space
+	}
+	return c.doc.nodes[c.i].prefix
+}
+
+// NamespaceURI returns the namespace URI of the node.
+func (c CompactNode) NamespaceURI() string {
+	if c.attr != noNode {
+		return c.doc.attrs[c.doc.nodes[c.i].attrStart+c.attr].NamespaceURI
+	}
+	return c.doc.nodes[c.i].namespaceURI
+}
+
+// Level returns the level of the node in the tree, as Node.Level.
+func (c CompactNode) Level() int {
+	if c.attr != noNode {
+		return int(c.doc.nodes[c.i].level) + 1
+	}
+	return int(c.doc.nodes[c.i].level)
+}
+
+// Attr returns the attributes of the node. The returned slice must not be
+// modified.
+func (c CompactNode) Attr() []Attr {
+	if c.attr != noNode {
+		return nil
+	}
+	n := &c.doc.nodes[c.i]
+	return c.doc.attrs[n.attrStart:n.attrEnd:n.attrEnd]
+}
+
+// SelectAttr returns the attribute value with the specified name.
+func (c CompactNode) SelectAttr(name string) string {
+	xmlName := newXMLName(name)
+	for _, attr := range c.Attr() {
+		if attr.Name == xmlName {
+			return attr.Value
+		}
+	}
+	return ""
+}
+
+// Parent returns the parent of the node. The parent of an attribute node
+// is its element.
+func (c CompactNode) Parent() CompactNode {
+	if c.attr != noNode {
+		return c.node(c.i)
+	}
+	return c.node(c.doc.nodes[c.i].parent)
+}
+
+// FirstChild returns the first child of the node.
+func (c CompactNode) FirstChild() CompactNode {
+	if c.attr != noNode {
+		return CompactNode{}
+	}
+	return c.node(c.doc.nodes[c.i].firstChild)
+}
+
+// LastChild returns the last child of the node.
+func (c CompactNode) LastChild() CompactNode {
+	if c.attr != noNode {
+		return CompactNode{}
+	}
+	return c.node(c.doc.nodes[c.i].lastChild)
+}
+
+// PrevSibling returns the previous sibling of the node.
+func (c CompactNode) PrevSibling() CompactNode {
+	if c.attr != noNode {
+		return CompactNode{}
+	}
+	return c.node(c.doc.nodes[c.i].prevSibling)
+}
+
+// NextSibling returns the next sibling of the node.
+func (c CompactNode) NextSibling() CompactNode {
+	if c.attr != noNode {
+		return CompactNode{}
+	}
+	return c.node(c.doc.nodes[c.i].nextSibling)
+}
+
+// InnerText returns the text between the start and end tags of the node,
+// as Node.InnerText.
+func (c CompactNode) InnerText() string {
+	if c.attr != noNode {
+		return c.doc.attrs[c.doc.nodes[c.i].attrStart+c.attr].Value
+	}
+	n := &c.doc.nodes[c.i]
+	switch n.typ {
+	case TextNode, CharDataNode:
+		return n.data
+	case CommentNode:
+		return ""
+	}
+	var b strings.Builder
+	for _, d := range c.doc.nodes[c.i+1 : n.end] {
+		if d.typ == TextNode || d.typ == CharDataNode {
+			b.WriteString(d.data)
+		}
+	}
+	return b.String()
+}
+
+// QueryAll searches the nodes that match the specified XPath expr, using
+// the node as the root of the query, as the package function QueryAll.
+func (c CompactNode) QueryAll(expr string) ([]CompactNode, error) {
+	exp, err := getQuery(expr, xpath.CompileOptions{})
+	if err != nil {
+		return nil, err
+	}
+	t := exp.Select(CreateCompactNavigator(c))
+	var list []CompactNode
+	for t.MoveNext() {
+		list = append(list, t.Current().(*CompactNavigator).CurrentNode())
+	}
+	return list, nil
+}
+
+// Query searches the first node that matches the specified XPath expr. It
+// returns a zero CompactNode if there is no match.
+func (c CompactNode) Query(expr string) (CompactNode, error) {
+	exp, err := getQuery(expr, xpath.CompileOptions{})
+	if err != nil {
+		return CompactNode{}, err
+	}
+	t := exp.Select(CreateCompactNavigator(c))
+	if t.MoveNext() {
+		return t.Current().(*CompactNavigator).CurrentNode(), nil
+	}
+	return CompactNode{}, nil
+}
+
+var _ xpath.NodeNavigator = &CompactNavigator{}
+
+// CompactNavigator is an xpath.NodeNavigator over a CompactDocument.
+type CompactNavigator struct {
+	doc        *CompactDocument
+	root, curr int32
+	attr       int32
+}
+
+// CreateCompactNavigator creates a new xpath.NodeNavigator for the
+// specified node of a CompactDocument.
+func CreateCompactNavigator(top CompactNode) *CompactNavigator {
+	return &CompactNavigator{doc: top.doc, root: top.i, curr: top.i, attr: noNode}
+}
+
+// CurrentNode returns the node the navigator is positioned on.
+func (x *CompactNavigator) CurrentNode() CompactNode {
+	return CompactNode{doc: x.doc, i: x.curr, attr: x.attr}
+}
+
+func (x *CompactNavigator) n() *compactNode {
+	return &x.doc.nodes[x.curr]
+}
+
+func (x *CompactNavigator) NodeType() xpath.NodeType {
+	switch x.n().typ {
+	case CommentNode:
+		return xpath.CommentNode
+	case TextNode, CharDataNode, NotationNode:
+		return xpath.TextNode
+	case DeclarationNode, DocumentNode:
+		return xpath.RootNode
+	case ElementNode:
+		if x.attr != noNode {
+			return xpath.AttributeNode
+		}
+		return xpath.ElementNode
+	}
+	panic(fmt.Sprintf("unknown XML node type: %v", x.n().typ))
+}
+
+func (x *CompactNavigator) LocalName() string {
+	return x.CurrentNode().Data()
+}
+
+func (x *CompactNavigator) Prefix() string {
+	return x.CurrentNode().Prefix()
+}
+
+func (x *CompactNavigator) NamespaceURL() string {
+	return x.CurrentNode().NamespaceURI()
+}
+
+func (x *CompactNavigator) Value() string {
+	switch x.n().typ {
+	case CommentNode, TextNode:
+		return x.n().data
+	case ElementNode:
+		return x.CurrentNode().InnerText()
+	}
+	return ""
+}
+
+func (x *CompactNavigator) Copy() xpath.NodeNavigator {
+	n := *x
+	return &n
+}
+
+func (x *CompactNavigator) MoveToRoot() {
+	x.curr = x.root
+	x.attr = noNode
+}
+
+func (x *CompactNavigator) MoveToParent() bool {
+	if x.attr != noNode {
+		x.attr = noNode
+		return true
+	} else if p := x.n().parent; p != noNode {
+		x.curr = p
+		return true
+	}
+	return false
+}
+
+func (x *CompactNavigator) MoveToNextAttribute() bool {
+	n := x.n()
+	if x.attr >= n.attrEnd-n.attrStart-1 {
+		return false
+	}
+	x.attr++
+	return true
+}
+
+func (x *CompactNavigator) MoveToChild() bool {
+	if x.attr != noNode {
+		return false
+	}
+	if c := x.n().firstChild; c != noNode {
+		x.curr = c
+		return true
+	}
+	return false
+}
+
+func (x *CompactNavigator) MoveToFirst() bool {
+	if x.attr != noNode {
+		return false
+	}
+	first := x.curr
+	for i := x.n().prevSibling; i != noNode; i = x.doc.nodes[i].prevSibling {
+		if n := &x.doc.nodes[i]; n.typ != TextNode || strings.TrimSpace(n.data) != "" {
+			first = i
+		}
+	}
+	if first == x.curr {
+		return false
+	}
+	x.curr = first
+	return true
+}
+
+func (x *CompactNavigator) String() string {
+	return x.Value()
+}
+
+func (x *CompactNavigator) MoveToNext() bool {
+	if x.attr != noNode {
+		return false
+	}
+	for next := x.n().nextSibling; next != noNode; next = x.n().nextSibling {
+		x.curr = next
+		if n := x.n(); n.typ != TextNode || strings.TrimSpace(n.data) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *CompactNavigator) MoveToPrevious() bool {
+	if x.attr != noNode {
+		return false
+	}
+	for prev := x.n().prevSibling; prev != noNode; prev = x.n().prevSibling {
+		x.curr = prev
+		if n := x.n(); n.typ != TextNode || strings.TrimSpace(n.data) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (x *CompactNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	node, ok := other.(*CompactNavigator)
+	if !ok || node.doc != x.doc || node.root != x.root {
+		return false
+	}
+	x.curr = node.curr
+	x.attr = node.attr
+	return true
+}
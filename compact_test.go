@@ -0,0 +1,58 @@
+package xmlquery
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCompactDocument(t *testing.T) {
+	c := NewCompactDocument(doc)
+	root := c.Root()
+	testValue(t, root.Type(), DocumentNode)
+
+	for _, expr := range []string{"//book", "//book[price < 10]/title", "//@id", "//comment()", "/catalog/book[last()]/author", "//book[1]/following-sibling::*"} {
+		expected := Find(doc, expr)
+		list, err := root.QueryAll(expr)
+		testTrue(t, err == nil)
+		testValue(t, len(list), len(expected))
+		for i, n := range list {
+			testValue(t, n.Type(), expected[i].Type)
+			testValue(t, n.Data(), expected[i].Data)
+			testValue(t, n.InnerText(), expected[i].InnerText())
+			testValue(t, n.Level(), expected[i].Level())
+		}
+	}
+
+	book, err := root.Query("//book[@id='bk102']")
+	testTrue(t, err == nil && book.Valid())
+	testValue(t, book.SelectAttr("id"), "bk102")
+	testValue(t, book.Parent().Data(), "catalog")
+	title, _ := book.Query("title")
+	testValue(t, title.InnerText(), "Midnight Rain")
+
+	// the same navigation as the original tree
+	n, m := FindOne(doc, "//book[@id='bk102']"), book
+	for m.Valid() {
+		testValue(t, m.Data(), n.Data)
+		testTrue(t, m.FirstChild().Valid() == (n.FirstChild != nil))
+		testTrue(t, m.LastChild().Valid() == (n.LastChild != nil))
+		testTrue(t, m.PrevSibling().Valid() == (n.PrevSibling != nil))
+		m, n = m.NextSibling(), n.NextSibling
+	}
+	testTrue(t, n == nil)
+
+	missing, err := root.Query("//magazine")
+	testTrue(t, err == nil && !missing.Valid())
+	_, err = root.QueryAll("//book[")
+	testTrue(t, err != nil)
+}
+
+func TestParseCompact(t *testing.T) {
+	c, err := ParseCompact(strings.NewReader(`<a x="1"><b>t</b></a>`))
+	testTrue(t, err == nil)
+	testValue(t, c.Len(), 5)
+	attr, _ := c.Root().Query("//@x")
+	testValue(t, attr.Type(), AttributeNode)
+	testValue(t, attr.InnerText(), "1")
+	testValue(t, attr.Parent().Data(), "a")
+}
@@ -0,0 +1,144 @@
+package xmlquery
+
+import "strings"
+
+// A ChangeKind is the kind of a Change.
+type ChangeKind uint
+
+const (
+	// ChangeAdded is an element, attribute or text present only in the
+	// second tree.
+	ChangeAdded ChangeKind = iota
+	// ChangeRemoved is an element, attribute or text present only in the
+	// first tree.
+	ChangeRemoved
+	// ChangeModified is an attribute or text whose value differs.
+	ChangeModified
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case ChangeAdded:
+		return "added"
+	case ChangeRemoved:
+		return "removed"
+	case ChangeModified:
+		return "modified"
+	}
+	return "unknown"
+}
+
+// A Change is a difference between two trees, as returned by Diff.
+type Change struct {
+	Kind ChangeKind
+	// Path locates the changed node, using the paths of Node.XPath and
+	// Node.Flatten.
+	Path string
+	// Old and New are the values in the first and in the second tree.
+	// They are empty for added or removed elements.
+	Old, New string
+}
+
+// Diff compares the trees 'a' and 'b' by matching their nodes by path and
+// returns the differences between them. A path without a position matches
+// the same path with position 1, so adding a second b element next to
+// /a/b reports /a/b[2] as added. Elements missing from one of the trees
+// are reported once, without their attributes and content. The removed and
+// modified nodes are listed first, in the document order of 'a', followed
+// by the added nodes in the document order of 'b'.
+func Diff(a, b *Node) []Change {
+	elemsA, elemsB := elementPaths(a), elementPaths(b)
+	inA, inB := pathSet(elemsA), pathSet(elemsB)
+	valuesA, valuesB := a.Flatten(), b.Flatten()
+	byPathA, byPathB := valueMap(valuesA), valueMap(valuesB)
+
+	var changes []Change
+	for _, path := range elemsA {
+		if key := canonicalPath(path); !inB[key] && (inB[parentPath(key)] || parentPath(key) == "") {
+			changes = append(changes, Change{Kind: ChangeRemoved, Path: path})
+		}
+	}
+	for _, v := range valuesA {
+		key := canonicalPath(v.Path)
+		if !inB[ownerPath(key)] {
+			continue
+		}
+		if w, ok := byPathB[key]; !ok {
+			changes = append(changes, Change{Kind: ChangeRemoved, Path: v.Path, Old: v.Value})
+		} else if w != v.Value {
+			changes = append(changes, Change{Kind: ChangeModified, Path: v.Path, Old: v.Value, New: w})
+		}
+	}
+	for _, path := range elemsB {
+		if key := canonicalPath(path); !inA[key] && (inA[parentPath(key)] || parentPath(key) == "") {
+			changes = append(changes, Change{Kind: ChangeAdded, Path: path})
+		}
+	}
+	for _, v := range valuesB {
+		key := canonicalPath(v.Path)
+		if !inA[ownerPath(key)] {
+			continue
+		}
+		if _, ok := byPathA[key]; !ok {
+			changes = append(changes, Change{Kind: ChangeAdded, Path: v.Path, New: v.Value})
+		}
+	}
+	return changes
+}
+
+// elementPaths returns the paths of the elements in the tree of n, in
+// document order.
+func elementPaths(n *Node) []string {
+	var list []string
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.Type == ElementNode {
+			list = append(list, n.elementPath())
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+	return list
+}
+
+func pathSet(paths []string) map[string]bool {
+	m := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		m[canonicalPath(path)] = true
+	}
+	return m
+}
+
+func valueMap(values []PathValue) map[string]string {
+	m := make(map[string]string, len(values))
+	for _, v := range values {
+		m[canonicalPath(v.Path)] = v.Value
+	}
+	return m
+}
+
+// canonicalPath gives a position to every element step of path.
+func canonicalPath(path string) string {
+	steps := strings.Split(path, "/")
+	for i, step := range steps {
+		if step != "" && !strings.HasPrefix(step, "@") && !strings.HasSuffix(step, "]") {
+			steps[i] = step + "[1]"
+		}
+	}
+	return strings.Join(steps, "/")
+}
+
+// parentPath returns the path without its last step.
+func parentPath(path string) string {
+	return path[:strings.LastIndexByte(path, '/')]
+}
+
+// ownerPath returns the path of the element holding the value at path.
+func ownerPath(path string) string {
+	if i := strings.LastIndexByte(path, '/'); i >= 0 && (strings.HasPrefix(path[i+1:], "@") || strings.HasPrefix(path[i+1:], "text()")) {
+		return path[:i]
+	}
+	return path
+}
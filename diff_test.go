@@ -0,0 +1,25 @@
+package xmlquery
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	a := loadXML(`<config version="1"><server host="a" port="80"><name>web</name></server><db>pg</db><cache/></config>`)
+	b := loadXML(`<config version="2"><server host="a"><name>www</name></server><server host="b"/><db>pg</db><log level="debug">on</log></config>`)
+
+	expected := []Change{
+		{Kind: ChangeRemoved, Path: "/config/cache"},
+		{Kind: ChangeModified, Path: "/config/@version", Old: "1", New: "2"},
+		{Kind: ChangeRemoved, Path: "/config/server/@port", Old: "80"},
+		{Kind: ChangeModified, Path: "/config/server/name", Old: "web", New: "www"},
+		{Kind: ChangeAdded, Path: "/config/server[2]"},
+		{Kind: ChangeAdded, Path: "/config/log"},
+	}
+	if changes := Diff(a, b); !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %v, but got %v", expected, changes)
+	}
+	testValue(t, len(Diff(a, a)), 0)
+	testValue(t, ChangeModified.String(), "modified")
+}
@@ -0,0 +1,74 @@
+package xmlquery
+
+import (
+	"fmt"
+	"strings"
+)
+
+var dotTypeNames = map[NodeType]string{
+	DocumentNode:    "document",
+	DeclarationNode: "declaration",
+	ElementNode:     "element",
+	TextNode:        "text",
+	CharDataNode:    "cdata",
+	CommentNode:     "comment",
+	AttributeNode:   "attribute",
+	NotationNode:    "notation",
+}
+
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", " ")
+
+// dotTextLimit is the number of characters of text shown in the labels.
+const dotTextLimit = 20
+
+// ToDOT returns the tree rooted at the current node as a Graphviz DOT
+// graph, to be rendered with a command such as `dot -Tsvg`. Each node is
+// labeled with its type and its name, or the start of its text, and is
+// linked to its children by solid edges and to its next sibling by a
+// dashed edge.
+func (n *Node) ToDOT() string {
+	var b strings.Builder
+	b.WriteString("digraph xml {\n\tnode [shape=box];\n")
+	ids := make(map[*Node]int)
+	for d := n; d != nil; d = nextDescendant(n, d) {
+		id := len(ids)
+		ids[d] = id
+		fmt.Fprintf(&b, "\tn%d [label=\"%s\"];\n", id, dotEscaper.Replace(dotLabel(d)))
+		if d != n {
+			fmt.Fprintf(&b, "\tn%d -> n%d;\n", ids[d.Parent], id)
+			if prev, ok := ids[d.PrevSibling]; ok {
+				fmt.Fprintf(&b, "\tn%d -> n%d [style=dashed, constraint=false];\n", prev, id)
+			}
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotLabel(n *Node) string {
+	label := dotTypeNames[n.Type]
+	switch n.Type {
+	case ElementNode, AttributeNode:
+		label += "\n" + n.qualifiedName()
+		for _, attr := range n.Attr {
+			name := attr.Name.Local
+			if attr.Name.Space != "" {
+				name = attr.Name.Space + ":" + name
+			}
+			label += "\n@" + name + "=" + dotText(attr.Value)
+		}
+	case DeclarationNode:
+		label += "\n" + n.Data
+	case TextNode, CharDataNode, CommentNode, NotationNode:
+		label += "\n" + dotText(n.Data)
+	}
+	return label
+}
+
+// dotText quotes s, shortened to dotTextLimit characters.
+func dotText(s string) string {
+	if r := []rune(s); len(r) > dotTextLimit {
+		s = string(r[:dotTextLimit]) + "…"
+	}
+	return `"` + s + `"`
+}
@@ -0,0 +1,22 @@
+package xmlquery
+
+import (
+	"testing"
+)
+
+func TestToDOT(t *testing.T) {
+	doc := loadXML(`<a id="1"><b>a "quoted" text that is rather long</b><!--c--></a>`)
+	want := `digraph xml {
+	node [shape=box];
+	n0 [label="element\na\n@id=\"1\""];
+	n1 [label="element\nb"];
+	n0 -> n1;
+	n2 [label="text\n\"a \"quoted\" text that…\""];
+	n1 -> n2;
+	n3 [label="comment\n\"c\""];
+	n0 -> n3;
+	n1 -> n3 [style=dashed, constraint=false];
+}
+`
+	testValue(t, FindOne(doc, "/a").ToDOT(), want)
+}
@@ -0,0 +1,19 @@
+//go:build go1.23
+
+package xmlquery
+
+import "iter"
+
+// DescendantsIter returns an iterator over the descendant nodes of the
+// current node, in the same order as Descendants. The nodes are visited as
+// the iteration proceeds, so breaking out of the loop stops the walk. The
+// tree must not be modified during the iteration.
+func (n *Node) DescendantsIter() iter.Seq[*Node] {
+	return func(yield func(*Node) bool) {
+		for d := nextDescendant(n, n); d != nil; d = nextDescendant(n, d) {
+			if !yield(d) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,29 @@
+//go:build go1.23
+
+package xmlquery
+
+import "testing"
+
+func TestDescendantsIter(t *testing.T) {
+	book := FindOne(doc, "//book[2]")
+	var list []*Node
+	for n := range book.DescendantsIter() {
+		list = append(list, n)
+	}
+	expected := book.Descendants()
+	testValue(t, len(list), len(expected))
+	for i := range list {
+		testValue(t, list[i], expected[i])
+	}
+
+	var names []string
+	for n := range doc.DescendantsIter() {
+		if n.Type == ElementNode {
+			if names = append(names, n.Data); len(names) == 3 {
+				break
+			}
+		}
+	}
+	testValue(t, len(names), 3)
+	testValue(t, names[2], "author")
+}
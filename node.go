@@ -2,11 +2,17 @@ package xmlquery
 
 import (
 	"bufio"
+	"encoding/binary"
 	"encoding/xml"
 	"fmt"
-	"html"
+	"hash"
+	"hash/fnv"
 	"io"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 // A NodeType is the type of a Node.
@@ -39,6 +45,13 @@ type Attr struct {
 	NamespaceURI string
 }
 
+// rawAttr is the source text of an attribute value, with its quotes, and
+// the value it was decoded to.
+type rawAttr struct {
+	name       xml.Name
+	raw, value string
+}
+
 // A Node consists of a NodeType and some Data (tag name for
 // element nodes, content for text) and are part of a tree of Nodes.
 type Node struct {
@@ -50,7 +63,9 @@ type Node struct {
 	NamespaceURI string
 	Attr         []Attr
 
-	level int // node level in the tree
+	level      int       // node level in the tree
+	selfClosed bool      // whether the element was written as an empty-element tag in the source
+	rawAttrs   []rawAttr // the source text of the attribute values, if parsed with KeepRawAttrValues
 }
 
 type outputConfiguration struct {
@@ -59,6 +74,15 @@ type outputConfiguration struct {
 	emptyElementTagSupport bool
 	skipComments           bool
 	useIndentation         string
+	nonSelfClosing         map[string]bool
+	namespacePrefixes      map[string]string
+	pendingNamespaces      []string // namespace URLs to declare on the next element written
+	sortAttributes         bool
+	newLine                string
+	minimalNamespaces      bool
+	sourceEmptyTags        bool
+	maxLineWidth           int
+	namespaceScope         map[string]string // namespaces declared by the elements being written, by prefix
 }
 
 type OutputOption func(*outputConfiguration)
@@ -78,6 +102,65 @@ func WithEmptyTagSupport() OutputOption {
 	}
 }
 
+// WithNonSelfClosing makes the elements with the given local names always
+// be written with an explicit end tag, such as <script></script>, even if
+// WithEmptyTagSupport is used.
+func WithNonSelfClosing(names ...string) OutputOption {
+	return func(oc *outputConfiguration) {
+		if oc.nonSelfClosing == nil {
+			oc.nonSelfClosing = make(map[string]bool, len(names))
+		}
+		for _, name := range names {
+			oc.nonSelfClosing[name] = true
+		}
+	}
+}
+
+// WithNamespacePrefixes rewrites the prefixes of the elements and
+// attributes in the namespaces given by prefixes, a map from namespace URL
+// to the desired prefix, where an empty prefix is the default namespace.
+// The declarations of these namespaces are removed from the elements and
+// the ones used in the output are declared once on the top-level element.
+// Attributes can't be in the default namespace, so they keep their prefix
+// if their namespace is mapped to an empty prefix. Mapping a namespace to
+// the default namespace moves elements without a namespace into it, so it
+// should only be used when there are no such elements.
+func WithNamespacePrefixes(prefixes map[string]string) OutputOption {
+	return func(oc *outputConfiguration) {
+		oc.namespacePrefixes = prefixes
+	}
+}
+
+// WithSortedAttributes writes the attributes of each element sorted by
+// namespace URI, then by local name, so the output doesn't depend on the
+// order of the attributes in the source. Namespace declarations come
+// first, sorted by prefix. This isn't a canonicalization of the document.
+func WithSortedAttributes() OutputOption {
+	return func(oc *outputConfiguration) {
+		oc.sortAttributes = true
+	}
+}
+
+// WithoutRedundantNamespaces leaves out the namespace declarations that
+// bind a prefix, or the default namespace, to the namespace it's already
+// bound to by an enclosing element of the output, such as the declarations
+// repeated by fragments assembled into one document.
+func WithoutRedundantNamespaces() OutputOption {
+	return func(oc *outputConfiguration) {
+		oc.minimalNamespaces = true
+	}
+}
+
+// WithSourceEmptyTags writes the empty elements parsed with
+// ParserOptions.RecordSelfClosing as they were in the source: <x/> for an
+// empty-element tag and <x></x> otherwise. It takes precedence over
+// WithEmptyTagSupport and WithNonSelfClosing.
+func WithSourceEmptyTags() OutputOption {
+	return func(oc *outputConfiguration) {
+		oc.sourceEmptyTags = true
+	}
+}
+
 // WithoutComments will skip comments in output
 func WithoutComments() OutputOption {
 	return func(oc *outputConfiguration) {
@@ -99,13 +182,34 @@ func WithoutPreserveSpace() OutputOption {
 	}
 }
 
-// WithIndentation sets the indentation string used for formatting the output.
+// WithIndentation sets the indentation string used for formatting the output,
+// such as two spaces or "\t", written once per nesting level.
 func WithIndentation(indentation string) OutputOption {
 	return func(oc *outputConfiguration) {
 		oc.useIndentation = indentation
 	}
 }
 
+// WithNewLine sets the line break written before each indented line when
+// WithIndentation is used, such as "\r\n" for Windows line endings. The
+// default is "\n".
+func WithNewLine(newLine string) OutputOption {
+	return func(oc *outputConfiguration) {
+		oc.newLine = newLine
+	}
+}
+
+// WithMaxLineWidth writes the attributes of a start tag wider than width
+// characters on separate lines, indented one level deeper than the element
+// with the WithIndentation string, or two spaces without indentation. Tags
+// with a single attribute are not wrapped. Zero, the default, disables the
+// wrapping.
+func WithMaxLineWidth(width int) OutputOption {
+	return func(oc *outputConfiguration) {
+		oc.maxLineWidth = width
+	}
+}
+
 func newXMLName(name string) xml.Name {
 	if i := strings.IndexByte(name, ':'); i > 0 {
 		return xml.Name{
@@ -118,6 +222,106 @@ func newXMLName(name string) xml.Name {
 	}
 }
 
+var textEscaper = strings.NewReplacer(
+	`&`, "&amp;",
+	`'`, "&#39;",
+	`<`, "&lt;",
+	`>`, "&gt;",
+	`"`, "&#34;",
+	"\r", "&#xD;",
+)
+
+var attrEscaper = strings.NewReplacer(
+	`&`, "&amp;",
+	`'`, "&#39;",
+	`<`, "&lt;",
+	`>`, "&gt;",
+	`"`, "&#34;",
+	"\t", "&#x9;",
+	"\n", "&#xA;",
+	"\r", "&#xD;",
+)
+
+// EscapeText escapes a string for use as XML character data, using
+// the same rules as the serializer. Carriage returns are escaped so
+// they are not turned into line feeds when the text is parsed again.
+func EscapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// EscapeAttr escapes a string for use as an XML attribute value. In
+// addition to the characters escaped by EscapeText, tabs and line
+// breaks are escaped so they survive attribute-value normalization.
+func EscapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
+
+// UnescapeText reverses EscapeText, resolving the predefined XML
+// entities and numeric character references. Unknown entities are
+// left as is.
+func UnescapeText(s string) string {
+	i := strings.IndexByte(s, '&')
+	if i < 0 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for i >= 0 {
+		b.WriteString(s[:i])
+		s = s[i:]
+		j := strings.IndexByte(s, ';')
+		if j < 0 {
+			break
+		}
+		if r, ok := resolveEntity(s[1:j]); ok {
+			b.WriteString(r)
+			s = s[j+1:]
+		} else {
+			b.WriteByte('&')
+			s = s[1:]
+		}
+		i = strings.IndexByte(s, '&')
+	}
+	b.WriteString(s)
+	return b.String()
+}
+
+// UnescapeAttr reverses EscapeAttr.
+func UnescapeAttr(s string) string {
+	return UnescapeText(s)
+}
+
+func resolveEntity(name string) (string, bool) {
+	switch name {
+	case "amp":
+		return "&", true
+	case "lt":
+		return "<", true
+	case "gt":
+		return ">", true
+	case "quot":
+		return `"`, true
+	case "apos":
+		return "'", true
+	}
+	if len(name) < 2 || name[0] != '#' {
+		return "", false
+	}
+	var (
+		v   uint64
+		err error
+	)
+	if name[1] == 'x' || name[1] == 'X' {
+		v, err = strconv.ParseUint(name[2:], 16, 32)
+	} else {
+		v, err = strconv.ParseUint(name[1:], 10, 32)
+	}
+	if err != nil {
+		return "", false
+	}
+	return string(rune(v)), true
+}
+
 func (n *Node) Level() int {
 	return n.level
 }
@@ -142,8 +346,765 @@ func (n *Node) InnerText() string {
 	return b.String()
 }
 
-// ChildNodes returns all the child nodes of the current node,
-// including text, comments, and char data.
+// PathValue is a value in the document together with the XPath-like path
+// locating it, as returned by Flatten.
+type PathValue struct {
+	Path  string
+	Value string
+}
+
+// Flatten returns the attributes and the text content of the current node
+// and of all its descendant elements as a flat list of (path, value)
+// pairs, in document order. The text of an element without child elements
+// is reported at the path of the element; text interleaved with child
+// elements is reported as text()[n] of its parent.
+func (n *Node) Flatten() []PathValue {
+	var list []PathValue
+	var walk func(*Node)
+	walk = func(n *Node) {
+		var path string
+		if n.Type == ElementNode {
+			path = n.elementPath()
+			for _, attr := range n.Attr {
+				name := attr.Name.Local
+				if attr.Name.Space != "" {
+					name = attr.Name.Space + ":" + name
+				}
+				list = append(list, PathValue{Path: path + "/@" + name, Value: attr.Value})
+			}
+		}
+		hasElement := false
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == ElementNode {
+				hasElement = true
+				break
+			}
+		}
+		if n.Type == ElementNode && !hasElement {
+			if text := n.InnerText(); text != "" {
+				list = append(list, PathValue{Path: path, Value: text})
+			}
+			return
+		}
+		pos := 0
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			switch child.Type {
+			case ElementNode:
+				walk(child)
+			case TextNode, CharDataNode:
+				pos++
+				if n.Type == ElementNode && strings.TrimSpace(child.Data) != "" {
+					list = append(list, PathValue{Path: fmt.Sprintf("%s/text()[%d]", path, pos), Value: child.Data})
+				}
+			}
+		}
+	}
+	walk(n)
+	return list
+}
+
+// XPath returns an absolute path locating the current node in its tree,
+// such as /a/b[2]/c or /a/b/text()[1]. A position is given for an element
+// only if there are siblings of the same name. The path can be resolved
+// against the same or another document with ResolvePath.
+func (n *Node) XPath() string {
+	switch n.Type {
+	case DocumentNode:
+		return "/"
+	case ElementNode:
+		return n.elementPath()
+	case AttributeNode:
+		if n.Parent != nil {
+			return n.Parent.XPath() + "/@" + n.qualifiedName()
+		}
+		return "/@" + n.qualifiedName()
+	}
+	test := "text()"
+	if n.Type == CommentNode {
+		test = "comment()"
+	}
+	var parent string
+	pos := 0
+	if n.Parent != nil {
+		if n.Parent.Type == ElementNode {
+			parent = n.Parent.elementPath()
+		}
+		for s := n.Parent.FirstChild; s != nil; s = s.NextSibling {
+			if s.kindTest() == test {
+				pos++
+			}
+			if s == n {
+				break
+			}
+		}
+	}
+	return fmt.Sprintf("%s/%s[%d]", parent, test, pos)
+}
+
+func (n *Node) kindTest() string {
+	switch n.Type {
+	case TextNode, CharDataNode:
+		return "text()"
+	case CommentNode:
+		return "comment()"
+	}
+	return ""
+}
+
+// IndexPath returns the position of the current node in its tree as the
+// index, counting from 0, of each node from the root down to it among the
+// child nodes of its parent, whatever their type. The root has an empty
+// path, and attribute nodes, which are not children, have none. It's the
+// inverse of NodeAtIndexPath.
+func (n *Node) IndexPath() []int {
+	if n.Type == AttributeNode {
+		return nil
+	}
+	path := []int{}
+	for ; n.Parent != nil; n = n.Parent {
+		i := 0
+		for sibling := n.PrevSibling; sibling != nil; sibling = sibling.PrevSibling {
+			i++
+		}
+		path = append(path, i)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return path
+}
+
+// NodeAtIndexPath returns the node whose IndexPath in the tree of root is
+// path, or nil if there is none.
+func NodeAtIndexPath(root *Node, path []int) *Node {
+	n := GetRoot(root)
+	for _, i := range path {
+		if n == nil || i < 0 {
+			return nil
+		}
+		n = n.FirstChild
+		for ; n != nil && i > 0; i-- {
+			n = n.NextSibling
+		}
+	}
+	return n
+}
+
+// ResolvePath returns the node located by path in the tree of 'top', or
+// nil if there is no such node. The path uses the grammar produced by
+// Node.XPath. An element step without a position selects the first
+// element of that name, so a path taken from one document can be applied
+// to another document of similar structure.
+func ResolvePath(top *Node, path string) *Node {
+	if top == nil || !strings.HasPrefix(path, "/") {
+		return nil
+	}
+	curr := GetRoot(top)
+	if path == "/" {
+		return curr
+	}
+	steps := strings.Split(path[1:], "/")
+	if curr.Type != DocumentNode {
+		// A detached tree: the first step selects the root itself.
+		curr = &Node{Type: DocumentNode, FirstChild: curr, LastChild: curr}
+	}
+	for i, step := range steps {
+		if curr == nil {
+			return nil
+		}
+		if strings.HasPrefix(step, "@") {
+			if i != len(steps)-1 {
+				return nil
+			}
+			name := newXMLName(step[1:])
+			for i, attr := range curr.Attr {
+				if attr.Name == name {
+					return curr.attributeNode(i)
+				}
+			}
+			return nil
+		}
+		name, pos := step, 1
+		if j := strings.IndexByte(step, '['); j > 0 && strings.HasSuffix(step, "]") {
+			v, err := strconv.Atoi(step[j+1 : len(step)-1])
+			if err != nil || v < 1 {
+				return nil
+			}
+			name, pos = step[:j], v
+		}
+		var next *Node
+		for child := curr.FirstChild; child != nil; child = child.NextSibling {
+			if name == "text()" || name == "comment()" {
+				if child.kindTest() != name {
+					continue
+				}
+			} else if child.Type != ElementNode || child.qualifiedName() != name {
+				continue
+			}
+			if pos--; pos == 0 {
+				next = child
+				break
+			}
+		}
+		curr = next
+	}
+	return curr
+}
+
+func (n *Node) qualifiedName() string {
+	if n.Prefix == "" {
+		return n.Data
+	}
+	return n.Prefix + ":" + n.Data
+}
+
+// elementPath returns the absolute path of the element n, such as
+// /a/b[2]/c. The position is only given when n has siblings of the same
+// name.
+func (n *Node) elementPath() string {
+	var segments []string
+	for e := n; e != nil && e.Type == ElementNode; e = e.Parent {
+		name := e.qualifiedName()
+		pos, count := 0, 0
+		if e.Parent != nil {
+			for s := e.Parent.FirstChild; s != nil; s = s.NextSibling {
+				if s.Type == ElementNode && s.Data == e.Data && s.Prefix == e.Prefix {
+					count++
+					if s == e {
+						pos = count
+					}
+				}
+			}
+		}
+		if count > 1 {
+			name = fmt.Sprintf("%s[%d]", name, pos)
+		}
+		segments = append(segments, name)
+	}
+	var b strings.Builder
+	for i := len(segments) - 1; i >= 0; i-- {
+		b.WriteByte('/')
+		b.WriteString(segments[i])
+	}
+	return b.String()
+}
+
+// ToMap converts the current node into a map. Attributes are keyed by their
+// name prefixed with "@", child elements by their name and the text of the
+// node, if not only whitespace, by "#text". A child element without
+// attributes or child elements is converted into its text, otherwise into
+// a map. The values of child elements with the same name are grouped into
+// a []interface{}, in document order.
+func (n *Node) ToMap() map[string]interface{} {
+	m := make(map[string]interface{})
+	for _, attr := range n.Attr {
+		name := attr.Name.Local
+		if attr.Name.Space != "" {
+			name = attr.Name.Space + ":" + name
+		}
+		m["@"+name] = attr.Value
+	}
+	var text strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case ElementNode:
+			var v interface{}
+			if len(child.Attr) == 0 && child.ChildElementCount() == 0 {
+				v = child.InnerText()
+			} else {
+				v = child.ToMap()
+			}
+			key := child.qualifiedName()
+			switch prev := m[key].(type) {
+			case nil:
+				m[key] = v
+			case []interface{}:
+				m[key] = append(prev, v)
+			default:
+				m[key] = []interface{}{prev, v}
+			}
+		case TextNode, CharDataNode:
+			text.WriteString(child.Data)
+		}
+	}
+	if strings.TrimSpace(text.String()) != "" {
+		m["#text"] = text.String()
+	}
+	return m
+}
+
+// FromMap builds an element with the given name from m, following the
+// conventions of ToMap: keys starting with "@" are attributes, "#text" is
+// the text of the element and other keys are child elements. A child value
+// can be a string or other scalar, used as the text of the child, a map,
+// converted recursively, a []interface{} of them, giving repeated children,
+// or nil, giving an empty child. Since maps are unordered, attributes and
+// child elements are added in the order of their names, after the text.
+// Prefixes declared by "@xmlns" attributes are resolved to their namespace.
+func FromMap(name string, m map[string]interface{}) (*Node, error) {
+	return fromMap(nil, name, m)
+}
+
+func fromMap(parent *Node, name string, m map[string]interface{}) (*Node, error) {
+	n, err := newMapElement(parent, name)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !strings.HasPrefix(key, "@") {
+			continue
+		}
+		attr := key[1:]
+		if !IsValidQName(attr) {
+			return nil, fmt.Errorf("xmlquery: invalid attribute name '%s'", attr)
+		}
+		value, ok := mapScalar(m[key])
+		if !ok {
+			return nil, fmt.Errorf("xmlquery: invalid value of type %T for attribute '%s'", m[key], attr)
+		}
+		AddAttr(n, attr, value)
+	}
+	resolveMapNamespaces(n)
+
+	if v, ok := m["#text"]; ok {
+		text, ok := mapScalar(v)
+		if !ok {
+			return nil, fmt.Errorf("xmlquery: invalid value of type %T for the text of '%s'", v, name)
+		}
+		AddChild(n, &Node{Type: TextNode, Data: text, level: n.level + 1})
+	}
+	for _, key := range keys {
+		if key == "#text" || strings.HasPrefix(key, "@") {
+			continue
+		}
+		values, ok := m[key].([]interface{})
+		if !ok {
+			values = []interface{}{m[key]}
+		}
+		for _, v := range values {
+			var child *Node
+			if cm, ok := v.(map[string]interface{}); ok {
+				child, err = fromMap(n, key, cm)
+			} else if text, ok := mapScalar(v); ok {
+				if child, err = newMapElement(n, key); err == nil && text != "" {
+					AddChild(child, &Node{Type: TextNode, Data: text, level: child.level + 1})
+				}
+			} else {
+				err = fmt.Errorf("xmlquery: invalid value of type %T for element '%s'", v, key)
+			}
+			if err != nil {
+				return nil, err
+			}
+			AddChild(n, child)
+		}
+	}
+	return n, nil
+}
+
+// newMapElement returns an element named name to be added to parent, which
+// may be nil, with its namespace resolved from the declarations in scope.
+func newMapElement(parent *Node, name string) (*Node, error) {
+	if !IsValidQName(name) {
+		return nil, fmt.Errorf("xmlquery: invalid element name '%s'", name)
+	}
+	xmlName := newXMLName(name)
+	n := &Node{Type: ElementNode, Data: xmlName.Local, Prefix: xmlName.Space, Parent: parent}
+	if parent != nil {
+		n.level = parent.level + 1
+	}
+	n.NamespaceURI = lookupNamespace(parent, n.Prefix)
+	return n, nil
+}
+
+// resolveMapNamespaces sets the namespace of n and of its attributes once
+// its namespace declarations are known.
+func resolveMapNamespaces(n *Node) {
+	n.NamespaceURI = lookupNamespace(n, n.Prefix)
+	for i, attr := range n.Attr {
+		switch {
+		case attr.Name.Space == "xmlns":
+			n.Attr[i].NamespaceURI = "xmlns"
+		case attr.Name.Space != "":
+			n.Attr[i].NamespaceURI = lookupNamespace(n, attr.Name.Space)
+		}
+	}
+}
+
+// lookupNamespace returns the namespace bound to prefix by the xmlns
+// attributes of n and its ancestors, or an empty string.
+func lookupNamespace(n *Node, prefix string) string {
+	if prefix == "xml" {
+		return xmlNamespaceURI
+	}
+	for ; n != nil; n = n.Parent {
+		for _, attr := range n.Attr {
+			if prefix == "" && attr.Name.Space == "" && attr.Name.Local == "xmlns" ||
+				prefix != "" && attr.Name.Space == "xmlns" && attr.Name.Local == prefix {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+// mapScalar returns the text of a scalar map value.
+func mapScalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return "", true
+	case string:
+		return v, true
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// FindTextNodes returns all the text and char data nodes in the subtree of
+// the current node whose content satisfies pred, in document order.
+func (n *Node) FindTextNodes(pred func(string) bool) []*Node {
+	var list []*Node
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			switch child.Type {
+			case TextNode, CharDataNode:
+				if pred(child.Data) {
+					list = append(list, child)
+				}
+			default:
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	return list
+}
+
+// Comments returns all the descendant comment nodes of the current node, in
+// document order.
+func (n *Node) Comments() []*Node {
+	var list []*Node
+	for d := nextDescendant(n, n); d != nil; d = nextDescendant(n, d) {
+		if d.Type == CommentNode {
+			list = append(list, d)
+		}
+	}
+	return list
+}
+
+// CommentText returns the text of the comments returned by Comments.
+func (n *Node) CommentText() []string {
+	var list []string
+	for _, c := range n.Comments() {
+		list = append(list, c.Data)
+	}
+	return list
+}
+
+// ReplaceText replaces all the occurrences of old with new in the
+// descendant text and CDATA nodes of the current node and returns the
+// number of replacements. Attribute values are not changed. An empty old
+// string replaces nothing.
+func (n *Node) ReplaceText(old, new string) int {
+	if old == "" {
+		return 0
+	}
+	count := 0
+	for _, t := range n.FindTextNodes(func(s string) bool { return strings.Contains(s, old) }) {
+		count += strings.Count(t.Data, old)
+		t.Data = strings.Replace(t.Data, old, new, -1)
+	}
+	return count
+}
+
+// ReplaceTextRegexp is like ReplaceText, but replaces the matches of re with
+// repl, in which $ signs are interpreted as in regexp.Regexp.Expand. The
+// matches are found in each text node separately.
+func (n *Node) ReplaceTextRegexp(re *regexp.Regexp, repl string) int {
+	count := 0
+	for _, t := range n.FindTextNodes(re.MatchString) {
+		count += len(re.FindAllStringIndex(t.Data, -1))
+		t.Data = re.ReplaceAllString(t.Data, repl)
+	}
+	return count
+}
+
+// Rename changes the name of the current element to name, which may have
+// a prefix, such as "ns:item". The namespace URI of the element becomes the
+// one bound to the prefix, or the default namespace if there is no prefix,
+// by the xmlns attributes of the element and its ancestors.
+func (n *Node) Rename(name string) {
+	xmlName := newXMLName(name)
+	n.Prefix, n.Data = xmlName.Space, xmlName.Local
+	n.NamespaceURI = lookupNamespace(n, n.Prefix)
+}
+
+// SetText replaces all the child nodes of the current node with a single
+// text node containing s.
+func (n *Node) SetText(s string) {
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		child.Parent, child.PrevSibling, child.NextSibling = nil, nil, nil
+		child = next
+	}
+	n.FirstChild, n.LastChild = nil, nil
+	AddChild(n, &Node{Type: TextNode, Data: s, level: n.level + 1})
+}
+
+// xmlDeclaration returns the <?xml?> declaration of the document the node
+// belongs to, or nil if there is none.
+func (n *Node) xmlDeclaration() *Node {
+	if n.Type == DeclarationNode && n.Data == "xml" {
+		return n
+	}
+	for child := GetRoot(n).FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == DeclarationNode && child.Data == "xml" {
+			return child
+		}
+	}
+	return nil
+}
+
+// PIAttributes returns the pseudo-attributes of a processing instruction,
+// such as the type and href of <?xml-stylesheet type="text/xsl"
+// href="s.xsl"?>, by name. The values are not unescaped. It returns nil if
+// the current node is not a processing instruction.
+func (n *Node) PIAttributes() map[string]string {
+	if n.Type != DeclarationNode {
+		return nil
+	}
+	m := make(map[string]string, len(n.Attr))
+	for _, attr := range n.Attr {
+		m[attr.Name.Local] = attr.Value
+	}
+	return m
+}
+
+// XMLVersion returns the version given by the XML declaration of the
+// document the node belongs to, or an empty string if there is none.
+func (n *Node) XMLVersion() string {
+	if decl := n.xmlDeclaration(); decl != nil {
+		return decl.SelectAttr("version")
+	}
+	return ""
+}
+
+// Encoding returns the encoding given by the XML declaration of the
+// document the node belongs to, or an empty string if there is none.
+func (n *Node) Encoding() string {
+	if decl := n.xmlDeclaration(); decl != nil {
+		return decl.SelectAttr("encoding")
+	}
+	return ""
+}
+
+// Standalone returns the standalone value given by the XML declaration of
+// the document the node belongs to. The second result reports whether the
+// declaration specifies it at all.
+func (n *Node) Standalone() (standalone bool, ok bool) {
+	if decl := n.xmlDeclaration(); decl != nil && decl.HasAttr("standalone") {
+		return decl.SelectAttr("standalone") == "yes", true
+	}
+	return false, false
+}
+
+// DocType returns the name of the root element and the public and system
+// identifiers declared by the <!DOCTYPE> declaration of the document the
+// node belongs to, such as "html", "-//W3C//DTD XHTML 1.0 Strict//EN" and
+// "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd". ok is false if the
+// document has no DOCTYPE declaration, which is the case when it's parsed
+// with ParserOptions.DisableDTD.
+func (n *Node) DocType() (name, publicID, systemID string, ok bool) {
+	for child := GetRoot(n).FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != NotationNode || !strings.HasPrefix(child.Data, "DOCTYPE") {
+			continue
+		}
+		fields := doctypeFields(child.Data[len("DOCTYPE"):])
+		if len(fields) == 0 {
+			return "", "", "", false
+		}
+		name = fields[0]
+		switch {
+		case len(fields) >= 4 && fields[1] == "PUBLIC":
+			publicID, systemID = fields[2], fields[3]
+		case len(fields) >= 3 && fields[1] == "PUBLIC":
+			publicID = fields[2]
+		case len(fields) >= 3 && fields[1] == "SYSTEM":
+			systemID = fields[2]
+		}
+		return name, publicID, systemID, true
+	}
+	return "", "", "", false
+}
+
+// doctypeFields splits the content of a DOCTYPE declaration into its
+// whitespace separated fields, with the quotes of the literals removed, up
+// to the internal subset.
+func doctypeFields(s string) []string {
+	var fields []string
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if s == "" || s[0] == '[' {
+			return fields
+		}
+		if s[0] == '"' || s[0] == '\'' {
+			end := strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				return fields
+			}
+			fields = append(fields, s[1:end+1])
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t\r\n[")
+		if end < 0 {
+			end = len(s)
+		}
+		fields = append(fields, s[:end])
+		s = s[end:]
+	}
+}
+
+// SetDeclaration sets the XML declaration of the document the node belongs
+// to, creating it as the first child of the document if there is none. If
+// the tree has no DocumentNode, such as a tree built from an element, one
+// is created with the root of the tree as its child: use OwnerDocument to
+// get it and serialize the declaration.
+//
+// An empty encoding is omitted. standalone is a string rather than a bool
+// so the pseudo-attribute can be omitted, with "", as well as written as
+// standalone="yes" or standalone="no".
+func (n *Node) SetDeclaration(version, encoding, standalone string) {
+	decl := n.xmlDeclaration()
+	if decl == nil {
+		root := GetRoot(n)
+		if root.Type != DocumentNode {
+			doc := &Node{Type: DocumentNode, level: root.level}
+			shiftLevel(root, 1)
+			AddChild(doc, root)
+			root = doc
+		}
+		decl = &Node{Type: DeclarationNode, Data: "xml", level: root.level + 1}
+		decl.Parent = root
+		if first := root.FirstChild; first != nil {
+			first.PrevSibling = decl
+			decl.NextSibling = first
+		} else {
+			root.LastChild = decl
+		}
+		root.FirstChild = decl
+	}
+	decl.Attr = nil
+	AddAttr(decl, "version", version)
+	if encoding != "" {
+		AddAttr(decl, "encoding", encoding)
+	}
+	if standalone != "" {
+		AddAttr(decl, "standalone", standalone)
+	}
+}
+
+// rawAttrValue returns the source text of the value of attr, or an empty
+// string if it's not kept or if the value has changed since it was parsed.
+func (n *Node) rawAttrValue(attr Attr) string {
+	for _, r := range n.rawAttrs {
+		if r.name == attr.Name && r.value == attr.Value {
+			return r.raw
+		}
+	}
+	return ""
+}
+
+// Lang returns the language in scope for the current node as declared by
+// the nearest xml:lang attribute on the node or one of its ancestors. It
+// returns an empty string if no language is declared.
+//
+// Lang and IsLang stand in for the XPath lang() function, which is not
+// supported in expressions: github.com/antchfx/xpath doesn't implement it,
+// so a predicate such as //p[lang('en')] is an error. See IsLang.
+func (n *Node) Lang() string {
+	for e := n; e != nil; e = e.Parent {
+		for _, attr := range e.Attr {
+			if attr.Name.Space == "xml" && attr.Name.Local == "lang" {
+				return attr.Value
+			}
+		}
+	}
+	return ""
+}
+
+// IsLang reports whether the language in scope for the current node is
+// lang or a sublanguage of it, ignoring case, following the rules of the
+// XPath lang() function: IsLang("en") is true for "en", "EN" and "en-US".
+//
+// As lang() can't be used in expressions, since github.com/antchfx/xpath
+// has no way to register functions either, select the candidates and
+// filter them with IsLang instead.
+func (n *Node) IsLang(lang string) bool {
+	v := n.Lang()
+	if len(v) < len(lang) || !strings.EqualFold(v[:len(lang)], lang) {
+		return false
+	}
+	return len(v) == len(lang) || v[len(lang)] == '-'
+}
+
+// IsComment reports whether the current node is a comment. The text of
+// the comment, without the <!-- and --> delimiters, is held in Data.
+func (n *Node) IsComment() bool {
+	return n.Type == CommentNode
+}
+
+// SelfClosed reports whether the element was written as an empty-element
+// tag, such as <x/>, in the source. It's only recorded by the parser with
+// ParserOptions.RecordSelfClosing.
+func (n *Node) SelfClosed() bool {
+	return n.selfClosed
+}
+
+// IsEmpty reports whether the current node has no child nodes at all, not
+// even whitespace text.
+func (n *Node) IsEmpty() bool {
+	return n.FirstChild == nil
+}
+
+// HasContent reports whether the current node has at least one child
+// element or one child text or char data node that is not only whitespace.
+// Comments are not considered content.
+func (n *Node) HasContent() bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		switch child.Type {
+		case ElementNode:
+			return true
+		case TextNode, CharDataNode:
+			if strings.TrimSpace(child.Data) != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NormalizedText returns the text of the current node like InnerText, with
+// the whitespace normalized as by the XPath normalize-space() function:
+// leading and trailing whitespace is removed and runs of whitespace are
+// replaced by a single space. Only the XML whitespace characters, that is
+// space, tab, carriage return and line feed, are whitespace; other Unicode
+// spaces such as the no-break space are kept.
+func (n *Node) NormalizedText() string {
+	fields := strings.FieldsFunc(n.InnerText(), func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\r' || r == '\n'
+	})
+	return strings.Join(fields, " ")
+}
+
+// ChildNodes returns all the child nodes of the current node in document
+// order, including text, comments, and char data.
 func (n *Node) ChildNodes() []*Node {
 	var list []*Node
 	for child := n.FirstChild; child != nil; child = child.NextSibling {
@@ -152,6 +1113,58 @@ func (n *Node) ChildNodes() []*Node {
 	return list
 }
 
+// ChildElementAt returns the i-th child element of the current node,
+// counting from 0 and skipping other child nodes such as text or comments.
+// Returns nil if i is out of range.
+func (n *Node) ChildElementAt(i int) *Node {
+	if i < 0 {
+		return nil
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == ElementNode {
+			if i == 0 {
+				return child
+			}
+			i--
+		}
+	}
+	return nil
+}
+
+// ChildElementCount returns the number of child elements of the current
+// node.
+func (n *Node) ChildElementCount() int {
+	count := 0
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == ElementNode {
+			count++
+		}
+	}
+	return count
+}
+
+// NextOfType returns the first following sibling of the current node whose
+// type is t, or nil if there is none.
+func (n *Node) NextOfType(t NodeType) *Node {
+	for sibling := n.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+		if sibling.Type == t {
+			return sibling
+		}
+	}
+	return nil
+}
+
+// PrevOfType returns the nearest preceding sibling of the current node
+// whose type is t, or nil if there is none.
+func (n *Node) PrevOfType(t NodeType) *Node {
+	for sibling := n.PrevSibling; sibling != nil; sibling = sibling.PrevSibling {
+		if sibling.Type == t {
+			return sibling
+		}
+	}
+	return nil
+}
+
 func (n *Node) sanitizedData(preserveSpaces bool) string {
 	if preserveSpaces {
 		return n.Data
@@ -172,16 +1185,21 @@ type indentation struct {
 	level    int
 	hasChild bool
 	indent   string
+	newLine  string
 	w        io.Writer
 }
 
-func newIndentation(indent string, w io.Writer) *indentation {
+func newIndentation(indent, newLine string, w io.Writer) *indentation {
 	if indent == "" {
 		return nil
 	}
+	if newLine == "" {
+		newLine = "\n"
+	}
 	return &indentation{
-		indent: indent,
-		w:      w,
+		indent:  indent,
+		newLine: newLine,
+		w:       w,
 	}
 }
 
@@ -189,7 +1207,7 @@ func (i *indentation) NewLine() (err error) {
 	if i == nil {
 		return
 	}
-	_, err = io.WriteString(i.w, "\n")
+	_, err = io.WriteString(i.w, i.newLine)
 	return
 }
 
@@ -222,7 +1240,7 @@ func (i *indentation) Close() (err error) {
 }
 
 func (i *indentation) writeIndent() (err error) {
-	_, err = io.WriteString(i.w, "\n")
+	_, err = io.WriteString(i.w, i.newLine)
 	if err != nil {
 		return
 	}
@@ -232,9 +1250,11 @@ func (i *indentation) writeIndent() (err error) {
 
 func outputXML(w io.Writer, n *Node, preserveSpaces bool, config *outputConfiguration, indent *indentation) (err error) {
 	preserveSpaces = calculatePreserveSpaces(n, preserveSpaces)
+	var tag *startTagWriter
+	var out io.Writer
 	switch n.Type {
 	case TextNode:
-		_, err = io.WriteString(w, html.EscapeString(n.sanitizedData(preserveSpaces)))
+		_, err = io.WriteString(w, EscapeText(n.sanitizedData(preserveSpaces)))
 		return
 	case CharDataNode:
 		_, err = fmt.Fprintf(w, "<![CDATA[%v]]>", n.Data)
@@ -259,35 +1279,68 @@ func outputXML(w io.Writer, n *Node, preserveSpaces bool, config *outputConfigur
 		if err = indent.Open(); err != nil {
 			return
 		}
-		if n.Prefix == "" {
-			_, err = io.WriteString(w, "<"+n.Data)
-		} else {
-			_, err = fmt.Fprintf(w, "<%s:%s", n.Prefix, n.Data)
+		if config.maxLineWidth > 0 {
+			// The start tag is written once its width is known.
+			tag = &startTagWriter{}
+			w, out = tag, w
 		}
-		if err != nil {
+		if _, err = io.WriteString(w, "<"+config.elementName(n)); err != nil {
+			return
+		}
+		if err = config.writePendingNamespaces(w); err != nil {
 			return
 		}
 	}
 
-	for _, attr := range n.Attr {
-		if attr.Name.Space != "" {
-			_, err = fmt.Fprintf(w, ` %s:%s=`, attr.Name.Space, attr.Name.Local)
-		} else {
-			_, err = fmt.Fprintf(w, ` %s=`, attr.Name.Local)
+	attrs := n.Attr
+	if config.sortAttributes && n.Type != DeclarationNode {
+		attrs = sortedAttrs(attrs)
+	}
+	for _, attr := range attrs {
+		name := attr.Name.Local
+		if n.Type != DeclarationNode {
+			if name = config.attrName(attr); name == "" || config.minimalNamespaces && config.isRedundantNamespace(attr) {
+				continue
+			}
+		} else if attr.Name.Space != "" {
+			name = attr.Name.Space + ":" + name
 		}
-		if err != nil {
+		if _, err = fmt.Fprintf(w, ` %s=`, name); err != nil {
 			return
 		}
 
-		_, err = fmt.Fprintf(w, `"%v"`, html.EscapeString(attr.Value))
+		if n.Type == DeclarationNode {
+			// The content of a processing instruction is not unescaped by
+			// the parser, so pseudo-attributes are written as they are.
+			if strings.Contains(attr.Value, `"`) {
+				_, err = fmt.Fprintf(w, `'%v'`, attr.Value)
+			} else {
+				_, err = fmt.Fprintf(w, `"%v"`, attr.Value)
+			}
+		} else if raw := n.rawAttrValue(attr); raw != "" {
+			// The value is unchanged since it was parsed.
+			_, err = io.WriteString(w, raw)
+		} else {
+			_, err = fmt.Fprintf(w, `"%v"`, EscapeAttr(attr.Value))
+		}
 		if err != nil {
 			return
 		}
 	}
+	if tag != nil {
+		w = out
+		if err = config.writeStartTag(w, tag, indent); err != nil {
+			return
+		}
+	}
 	if n.Type == DeclarationNode {
 		_, err = io.WriteString(w, "?>")
 	} else {
-		if n.FirstChild != nil || !config.emptyElementTagSupport {
+		selfClosing := n.FirstChild == nil && config.emptyElementTagSupport && !config.nonSelfClosing[n.Data]
+		if config.sourceEmptyTags {
+			selfClosing = n.FirstChild == nil && n.selfClosed
+		}
+		if !selfClosing {
 			_, err = io.WriteString(w, ">")
 		} else {
 			_, err = io.WriteString(w, "/>")
@@ -301,23 +1354,174 @@ func outputXML(w io.Writer, n *Node, preserveSpaces bool, config *outputConfigur
 	if err != nil {
 		return
 	}
+	if config.minimalNamespaces {
+		scope := config.namespaceScope
+		config.namespaceScope = declaredNamespaces(n, scope)
+		defer func() { config.namespaceScope = scope }()
+	}
+	childIndent := indent
+	if indent != nil && hasText(n) {
+		// Indenting mixed content would change its text.
+		childIndent = nil
+	}
 	for child := n.FirstChild; child != nil; child = child.NextSibling {
-		err = outputXML(w, child, preserveSpaces, config, indent)
+		err = outputXML(w, child, preserveSpaces, config, childIndent)
 		if err != nil {
 			return
 		}
 	}
-	if n.Type != DeclarationNode {
-		if err = indent.Close(); err != nil {
-			return
+	if n.Type != DeclarationNode {
+		if err = indent.Close(); err != nil {
+			return
+		}
+		_, err = fmt.Fprintf(w, "</%s>", config.elementName(n))
+	}
+	return
+}
+
+// startTagWriter collects a start tag, without its closing >, and the
+// offsets of the attributes in it, which are each written starting with a
+// space.
+type startTagWriter struct {
+	strings.Builder
+	attrs []int
+}
+
+func (tw *startTagWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 && p[0] == ' ' {
+		tw.attrs = append(tw.attrs, tw.Len())
+	}
+	return tw.Builder.Write(p)
+}
+
+// writeStartTag writes the start tag collected in tag, with each attribute
+// on its own line if it has several and it's wider than config.maxLineWidth.
+func (config *outputConfiguration) writeStartTag(w io.Writer, tag *startTagWriter, indent *indentation) (err error) {
+	s := tag.String()
+	unit, newLine, depth := "  ", "\n", 0
+	if indent != nil {
+		unit, newLine, depth = indent.indent, indent.newLine, indent.level-1
+	}
+	if len(tag.attrs) < 2 || depth*len(unit)+len(s)+len(">") <= config.maxLineWidth {
+		_, err = io.WriteString(w, s)
+		return
+	}
+	if _, err = io.WriteString(w, s[:tag.attrs[0]]); err != nil {
+		return
+	}
+	prefix := newLine + strings.Repeat(unit, depth+1)
+	for i, start := range tag.attrs {
+		end := len(s)
+		if i+1 < len(tag.attrs) {
+			end = tag.attrs[i+1]
+		}
+		if _, err = io.WriteString(w, prefix+s[start+1:end]); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// isRedundantNamespace reports whether attr declares a namespace that is
+// already in scope with the same prefix in the output.
+func (config *outputConfiguration) isRedundantNamespace(attr Attr) bool {
+	prefix, ok := namespaceDeclPrefix(attr)
+	if !ok {
+		return false
+	}
+	uri, inScope := config.namespaceScope[prefix]
+	// No default namespace is the same as an empty one.
+	return uri == attr.Value && (inScope || prefix == "")
+}
+
+// hasText reports whether n has a child text node that isn't only
+// whitespace.
+func hasText(n *Node) bool {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if (child.Type == TextNode || child.Type == CharDataNode) && strings.TrimSpace(child.Data) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// elementName returns the qualified name to write for the element n.
+func (config *outputConfiguration) elementName(n *Node) string {
+	prefix := n.Prefix
+	if p, ok := config.namespacePrefixes[n.NamespaceURI]; ok && n.NamespaceURI != "" {
+		prefix = p
+	}
+	if prefix == "" {
+		return n.Data
+	}
+	return prefix + ":" + n.Data
+}
+
+// attrName returns the qualified name to write for the attribute, or an
+// empty string if the attribute is a namespace declaration to leave out.
+func (config *outputConfiguration) attrName(attr Attr) string {
+	prefix := attr.Name.Space
+	if config.namespacePrefixes != nil {
+		// A prefixed declaration of a namespace mapped to the default
+		// namespace is kept, as attributes may still use its prefix.
+		if p, ok := config.namespacePrefixes[attr.Value]; ok && (prefix == "xmlns" && p != "" || prefix == "" && attr.Name.Local == "xmlns") {
+			return ""
+		}
+		if p, ok := config.namespacePrefixes[attr.NamespaceURI]; ok && p != "" && attr.NamespaceURI != "" {
+			prefix = p
+		}
+	}
+	if prefix == "" {
+		return attr.Name.Local
+	}
+	return prefix + ":" + attr.Name.Local
+}
+
+// writePendingNamespaces writes the declarations of the namespaces hoisted
+// by WithNamespacePrefixes.
+func (config *outputConfiguration) writePendingNamespaces(w io.Writer) (err error) {
+	for _, url := range config.pendingNamespaces {
+		if prefix := config.namespacePrefixes[url]; prefix == "" {
+			_, err = fmt.Fprintf(w, ` xmlns="%s"`, EscapeAttr(url))
+		} else {
+			_, err = fmt.Fprintf(w, ` xmlns:%s="%s"`, prefix, EscapeAttr(url))
+		}
+		if err != nil {
+			return
+		}
+	}
+	config.pendingNamespaces = nil
+	return
+}
+
+// usedNamespaces returns the namespace URLs of the elements and attributes
+// in the subtree of n which have a prefix in the given map, in document
+// order.
+func usedNamespaces(n *Node, prefixes map[string]string) []string {
+	var list []string
+	seen := make(map[string]bool)
+	add := func(url string) {
+		if _, ok := prefixes[url]; ok && url != "" && !seen[url] {
+			seen[url] = true
+			list = append(list, url)
+		}
+	}
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if n.Type == ElementNode {
+			add(n.NamespaceURI)
+			for _, attr := range n.Attr {
+				if prefixes[attr.NamespaceURI] != "" {
+					add(attr.NamespaceURI)
+				}
+			}
 		}
-		if n.Prefix == "" {
-			_, err = fmt.Fprintf(w, "</%s>", n.Data)
-		} else {
-			_, err = fmt.Fprintf(w, "</%s:%s>", n.Prefix, n.Data)
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
 		}
 	}
-	return
+	walk(n)
+	return list
 }
 
 // OutputXML returns the text that including tags name.
@@ -357,7 +1561,10 @@ func (n *Node) WriteWithOptions(writer io.Writer, opts ...OutputOption) (err err
 	b := bufio.NewWriter(writer)
 	defer b.Flush()
 
-	ident := newIndentation(config.useIndentation, b)
+	ident := newIndentation(config.useIndentation, config.newLine, b)
+	if config.namespacePrefixes != nil {
+		config.pendingNamespaces = usedNamespaces(n, config.namespacePrefixes)
+	}
 	if config.printSelf && n.Type != DocumentNode {
 		err = outputXML(b, n, preserveSpaces, config, ident)
 	} else {
@@ -371,6 +1578,70 @@ func (n *Node) WriteWithOptions(writer io.Writer, opts ...OutputOption) (err err
 	return
 }
 
+// sortedAttrs returns a copy of attrs in the order of WithSortedAttributes.
+func sortedAttrs(attrs []Attr) []Attr {
+	sorted := make([]Attr, len(attrs))
+	copy(sorted, attrs)
+	isNamespace := func(attr Attr) bool {
+		return attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns"
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if isNamespace(a) || isNamespace(b) {
+			if !isNamespace(a) || !isNamespace(b) {
+				return isNamespace(a)
+			}
+			// the default namespace, with an empty space, comes first
+			return a.Name.Space+":"+a.Name.Local < b.Name.Space+":"+b.Name.Local
+		}
+		if a.NamespaceURI != b.NamespaceURI {
+			return a.NamespaceURI < b.NamespaceURI
+		}
+		return a.Name.Local < b.Name.Local
+	})
+	return sorted
+}
+
+// SerializeNodes returns the concatenated outer XML of the nodes, in the
+// order given, such as the results of a query.
+func SerializeNodes(nodes []*Node) string {
+	var b strings.Builder
+	_ = WriteNodes(&b, nodes)
+	return b.String()
+}
+
+// WriteNodes writes the outer XML of each of the nodes to the given writer,
+// in the order given. The options apply to every node, as for
+// WriteWithOptions; WithOutputSelf is implied.
+func WriteNodes(writer io.Writer, nodes []*Node, opts ...OutputOption) error {
+	opts = append(opts[:len(opts):len(opts)], WithOutputSelf())
+	for _, n := range nodes {
+		if err := n.WriteWithOptions(writer, opts...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attributeNode returns a new AttributeNode for the i-th attribute of n.
+// A node is created each time, use SameNode to compare attribute nodes.
+func (n *Node) attributeNode(i int) *Node {
+	attr := n.Attr[i]
+	child := &Node{Type: TextNode, Data: attr.Value, level: n.level + 2}
+	a := &Node{
+		Parent:       n,
+		Type:         AttributeNode,
+		Data:         attr.Name.Local,
+		Prefix:       attr.Name.Space,
+		NamespaceURI: attr.NamespaceURI,
+		FirstChild:   child,
+		LastChild:    child,
+		level:        n.level + 1,
+	}
+	child.Parent = a
+	return a
+}
+
 // AddAttr adds a new attribute specified by 'key' and 'val' to a node 'n'.
 // Returns false if the attribute already exists.
 func AddAttr(n *Node, key, val string) bool {
@@ -396,6 +1667,288 @@ func (n *Node) HasAttr(key string) bool {
 	return false
 }
 
+// FindWithAttr returns all the descendant elements of the current node
+// that have the attribute with the specified name, whatever its value, in
+// document order. As for HasAttr, a name with a prefix, such as
+// "xlink:href", only matches attributes with that prefix, and a name
+// without a prefix only matches attributes without one.
+func (n *Node) FindWithAttr(name string) []*Node {
+	var list []*Node
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == ElementNode {
+				if child.HasAttr(name) {
+					list = append(list, child)
+				}
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	return list
+}
+
+// Descendants returns all the descendant nodes of the current node, of any
+// type, in document order. DescendantsIter visits them without building
+// the slice.
+func (n *Node) Descendants() []*Node {
+	var list []*Node
+	for d := nextDescendant(n, n); d != nil; d = nextDescendant(n, d) {
+		list = append(list, d)
+	}
+	return list
+}
+
+// nextDescendant returns the node following d in document order within the
+// subtree rooted at top, or nil at the end of the subtree.
+func nextDescendant(top, d *Node) *Node {
+	if d.FirstChild != nil {
+		return d.FirstChild
+	}
+	for ; d != top; d = d.Parent {
+		if d.NextSibling != nil {
+			return d.NextSibling
+		}
+	}
+	return nil
+}
+
+// NSMatchOptions relaxes the comparison of namespace URIs by
+// MatchNamespace. The zero value compares them exactly, as required by the
+// Namespaces in XML specification.
+type NSMatchOptions struct {
+	// IgnoreTrailingSlash ignores a trailing slash, so that
+	// http://example.com/ns and http://example.com/ns/ match.
+	IgnoreTrailingSlash bool
+	// IgnoreSchemeHostCase compares the scheme and the host of the URIs
+	// without regard to case, so that HTTP://Example.com/ns and
+	// http://example.com/ns match. The rest of the URIs is still compared
+	// exactly.
+	IgnoreSchemeHostCase bool
+}
+
+// MatchNamespace reports whether the namespace URIs a and b are the same,
+// compared according to opts.
+func MatchNamespace(a, b string, opts NSMatchOptions) bool {
+	if opts.IgnoreTrailingSlash {
+		a, b = strings.TrimSuffix(a, "/"), strings.TrimSuffix(b, "/")
+	}
+	if opts.IgnoreSchemeHostCase {
+		a, b = lowerSchemeHost(a), lowerSchemeHost(b)
+	}
+	return a == b
+}
+
+// lowerSchemeHost returns uri with its scheme and, if it has one, its
+// authority in lower case.
+func lowerSchemeHost(uri string) string {
+	i := strings.IndexByte(uri, ':')
+	if i <= 0 || strings.ContainsAny(uri[:i], "/?#") {
+		return uri
+	}
+	end := i + 1
+	if strings.HasPrefix(uri[end:], "//") {
+		end += 2
+		if j := strings.IndexAny(uri[end:], "/?#"); j >= 0 {
+			end += j
+		} else {
+			end = len(uri)
+		}
+	}
+	return strings.ToLower(uri[:end]) + uri[end:]
+}
+
+// InNamespace returns the descendant elements of the current node whose
+// namespace URI is uri, in document order. Elements in other namespaces
+// are searched as well, so elements nested in foreign content are found.
+func (n *Node) InNamespace(uri string) []*Node {
+	return n.InNamespaceWithOptions(uri, NSMatchOptions{})
+}
+
+// InNamespaceWithOptions is like InNamespace, with the namespace URIs
+// compared as by MatchNamespace with opts.
+func (n *Node) InNamespaceWithOptions(uri string, opts NSMatchOptions) []*Node {
+	var list []*Node
+	var walk func(*Node)
+	walk = func(n *Node) {
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if child.Type == ElementNode {
+				if MatchNamespace(child.NamespaceURI, uri, opts) {
+					list = append(list, child)
+				}
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	return list
+}
+
+// CountElements returns the number of descendant elements of the current
+// node whose local name is name, as count(.//name) without a namespace
+// check, but without building the list of elements.
+func (n *Node) CountElements(name string) int {
+	count := 0
+	for d := nextDescendant(n, n); d != nil; d = nextDescendant(n, d) {
+		if d.Type == ElementNode && d.Data == name {
+			count++
+		}
+	}
+	return count
+}
+
+// ElementNames returns the distinct local names of the descendant elements
+// of the current node, in the order of their first appearance. Elements of
+// different namespaces sharing a local name are counted once; use
+// ElementExpandedNames to tell them apart.
+func (n *Node) ElementNames() []string {
+	var names []string
+	seen := make(map[string]bool)
+	for d := nextDescendant(n, n); d != nil; d = nextDescendant(n, d) {
+		if d.Type == ElementNode && !seen[d.Data] {
+			seen[d.Data] = true
+			names = append(names, d.Data)
+		}
+	}
+	return names
+}
+
+// AttributeFrequency returns the number of occurrences of each attribute
+// name, with its prefix if it has one, on the descendant elements of the
+// current node. Namespace declarations are not counted.
+func (n *Node) AttributeFrequency() map[string]int {
+	counts := make(map[string]int)
+	for d := nextDescendant(n, n); d != nil; d = nextDescendant(n, d) {
+		if d.Type != ElementNode {
+			continue
+		}
+		for _, attr := range d.Attr {
+			if _, ok := namespaceDeclPrefix(attr); ok {
+				continue
+			}
+			name := attr.Name.Local
+			if attr.Name.Space != "" {
+				name = attr.Name.Space + ":" + name
+			}
+			counts[name]++
+		}
+	}
+	return counts
+}
+
+// ElementFrequency returns the number of occurrences of each element name,
+// with its prefix if it has one, among the descendant elements of the
+// current node.
+func (n *Node) ElementFrequency() map[string]int {
+	counts := make(map[string]int)
+	for d := nextDescendant(n, n); d != nil; d = nextDescendant(n, d) {
+		if d.Type == ElementNode {
+			counts[d.qualifiedName()]++
+		}
+	}
+	return counts
+}
+
+// ElementExpandedNames returns the distinct expanded names, namespace URI
+// and local name, of the descendant elements of the current node, in the
+// order of their first appearance.
+func (n *Node) ElementExpandedNames() []xml.Name {
+	var names []xml.Name
+	seen := make(map[xml.Name]bool)
+	for d := nextDescendant(n, n); d != nil; d = nextDescendant(n, d) {
+		if d.Type != ElementNode {
+			continue
+		}
+		name := xml.Name{Space: d.NamespaceURI, Local: d.Data}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// FirstElementText returns the InnerText of the first descendant element,
+// in document order, whose local name is name, or an empty string if there
+// is no such element. The name is matched literally, not as an XPath
+// expression.
+func (n *Node) FirstElementText(name string) string {
+	if e := firstElement(n, name); e != nil {
+		return e.InnerText()
+	}
+	return ""
+}
+
+func firstElement(n *Node, name string) *Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != ElementNode {
+			continue
+		}
+		if child.Data == name {
+			return child
+		}
+		if e := firstElement(child, name); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+// FollowingElements returns the elements of the XPath following axis of
+// n, in document order: the elements that start after the end of n,
+// excluding its descendants. For an attribute node, the descendants of its
+// element are included.
+func (n *Node) FollowingElements() []*Node {
+	var list []*Node
+	if n.Type == AttributeNode && n.Parent != nil {
+		for child := n.Parent.FirstChild; child != nil; child = child.NextSibling {
+			list = appendElements(list, child)
+		}
+		n = n.Parent
+	}
+	for ; n != nil; n = n.Parent {
+		for sibling := n.NextSibling; sibling != nil; sibling = sibling.NextSibling {
+			list = appendElements(list, sibling)
+		}
+	}
+	return list
+}
+
+// PrecedingElements returns the elements of the XPath preceding axis of
+// n, in document order: the elements that end before the start of n,
+// excluding its ancestors.
+func (n *Node) PrecedingElements() []*Node {
+	if n.Type == AttributeNode {
+		n = n.Parent
+	}
+	var ancestors []*Node
+	for ; n != nil && n.Parent != nil; n = n.Parent {
+		ancestors = append(ancestors, n)
+	}
+	var list []*Node
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		a := ancestors[i]
+		for sibling := a.Parent.FirstChild; sibling != nil && sibling != a; sibling = sibling.NextSibling {
+			list = appendElements(list, sibling)
+		}
+	}
+	return list
+}
+
+// appendElements appends n and its descendants that are elements to list,
+// in document order.
+func appendElements(list []*Node, n *Node) []*Node {
+	if n.Type != ElementNode {
+		return list
+	}
+	list = append(list, n)
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		list = appendElements(list, child)
+	}
+	return list
+}
+
 // SetAttr allows an attribute value with the specified name to be changed.
 // If the attribute did not previously exist, it will be created.
 func (n *Node) SetAttr(key, value string) bool {
@@ -436,6 +1989,152 @@ func AddChild(parent, n *Node) {
 	parent.LastChild = n
 }
 
+// CreateElement returns a new detached element with the local name local
+// in the namespace namespaceURI, written with prefix, which may be empty.
+// The namespace is not declared by the element: add an xmlns attribute with
+// AddAttr unless an ancestor of its future position declares it.
+func CreateElement(prefix, local, namespaceURI string) *Node {
+	return &Node{Type: ElementNode, Data: local, Prefix: prefix, NamespaceURI: namespaceURI}
+}
+
+// CreateText returns a new detached text node holding s.
+func CreateText(s string) *Node {
+	return &Node{Type: TextNode, Data: s}
+}
+
+// CreateComment returns a new detached comment holding s, without the
+// <!-- and --> delimiters.
+func CreateComment(s string) *Node {
+	return &Node{Type: CommentNode, Data: s}
+}
+
+// CreateCData returns a new detached CDATA section holding s.
+func CreateCData(s string) *Node {
+	return &Node{Type: CharDataNode, Data: s}
+}
+
+// AppendXML parses the XML fragment and appends its top-level nodes to the
+// child nodes of the current node. The prefixes declared by the current
+// node and its ancestors may be used in the fragment. Nothing is appended
+// if the fragment is invalid.
+func (n *Node) AppendXML(fragment string) error {
+	var scope map[string]string
+	var ancestors []*Node
+	for a := n; a != nil; a = a.Parent {
+		ancestors = append(ancestors, a)
+	}
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		scope = declaredNamespaces(ancestors[i], scope)
+	}
+	prefixes := make([]string, 0, len(scope))
+	for prefix := range scope {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	var namespaces strings.Builder
+	for _, prefix := range prefixes {
+		if prefix == "" {
+			namespaces.WriteString(` xmlns="`)
+		} else {
+			namespaces.WriteString(` xmlns:` + prefix + `="`)
+		}
+		namespaces.WriteString(EscapeAttr(scope[prefix]) + `"`)
+	}
+	nodes, err := parseFragment(strings.NewReader(fragment), namespaces.String())
+	if err != nil {
+		return err
+	}
+	for _, child := range nodes {
+		shiftLevel(child, n.level+1-child.level)
+		AddChild(n, child)
+	}
+	return nil
+}
+
+// AppendChildren moves all the child nodes of 'other' to the end of the
+// child nodes of 'n', leaving 'other' without children.
+func (n *Node) AppendChildren(other *Node) {
+	if other == n {
+		return
+	}
+	for child := other.FirstChild; child != nil; {
+		next := child.NextSibling
+		shiftLevel(child, n.level+1-child.level)
+		AddChild(n, child)
+		child = next
+	}
+	other.FirstChild, other.LastChild = nil, nil
+}
+
+// CopyChildrenFrom appends deep copies of all the child nodes of 'other' to
+// the child nodes of 'n'. 'other' is left unchanged.
+func (n *Node) CopyChildrenFrom(other *Node) {
+	var copies []*Node
+	for child := other.FirstChild; child != nil; child = child.NextSibling {
+		copies = append(copies, cloneNode(child, n.level+1))
+	}
+	for _, c := range copies {
+		AddChild(n, c)
+	}
+}
+
+// cloneNode returns a deep copy of n, detached from any tree, with the
+// given level.
+func cloneNode(n *Node, level int) *Node {
+	return cloneNodeDepth(n, level, -1)
+}
+
+// cloneNodeDepth is like cloneNode, but only copies the descendants up to
+// depth levels below n if depth is not negative. The children of the
+// deepest nodes copied are replaced by a comment telling how many nodes
+// were left out.
+func cloneNodeDepth(n *Node, level, depth int) *Node {
+	c := &Node{
+		Type:         n.Type,
+		Data:         n.Data,
+		Prefix:       n.Prefix,
+		NamespaceURI: n.NamespaceURI,
+		level:        level,
+		selfClosed:   n.selfClosed,
+	}
+	if n.Attr != nil {
+		c.Attr = make([]Attr, len(n.Attr))
+		copy(c.Attr, n.Attr)
+	}
+	if n.rawAttrs != nil {
+		c.rawAttrs = make([]rawAttr, len(n.rawAttrs))
+		copy(c.rawAttrs, n.rawAttrs)
+	}
+	if depth == 0 && n.FirstChild != nil {
+		count := 0
+		for d := nextDescendant(n, n); d != nil; d = nextDescendant(n, d) {
+			count++
+		}
+		marker := fmt.Sprintf(" %d nodes elided ", count)
+		if count == 1 {
+			marker = " 1 node elided "
+		}
+		AddChild(c, &Node{Type: CommentNode, Data: marker, level: level + 1})
+		return c
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		AddChild(c, cloneNodeDepth(child, level+1, depth-1))
+	}
+	return c
+}
+
+// Truncate returns a deep copy of the current node, detached from its tree,
+// keeping only the descendants up to maxDepth levels below it: with a
+// maxDepth of 1, only the children are kept. The children of the deepest
+// elements kept are replaced by a single comment telling how many nodes
+// were left out, such as <!-- 12 nodes elided -->.
+func (n *Node) Truncate(maxDepth int) *Node {
+	if maxDepth < 0 {
+		maxDepth = 0
+	}
+	return cloneNodeDepth(n, n.level, maxDepth)
+}
+
 // AddSibling adds a new node 'n' as a last node of sibling chain for a given node 'sibling'.
 func AddSibling(sibling, n *Node) {
 	for t := sibling.NextSibling; t != nil; t = t.NextSibling {
@@ -463,6 +2162,23 @@ func AddImmediateSibling(sibling, n *Node) {
 	}
 }
 
+// NodesBetween returns the sibling nodes from 'start' to 'end', both
+// inclusive. Returns an error if 'end' is not 'start' or one of its
+// following siblings.
+func NodesBetween(start, end *Node) ([]*Node, error) {
+	if start == nil || end == nil || start.Parent != end.Parent {
+		return nil, fmt.Errorf("xmlquery: nodes are not siblings")
+	}
+	var list []*Node
+	for n := start; n != nil; n = n.NextSibling {
+		list = append(list, n)
+		if n == end {
+			return list, nil
+		}
+	}
+	return nil, fmt.Errorf("xmlquery: end node does not follow start node")
+}
+
 // RemoveFromTree removes a node and its subtree from the document
 // tree it is in. If the node is the root of the tree, then it's no-op.
 func RemoveFromTree(n *Node) {
@@ -491,6 +2207,155 @@ func RemoveFromTree(n *Node) {
 	n.NextSibling = nil
 }
 
+// Wrap replaces the node 'n' in its tree by 'wrapper' and adds 'n' to
+// 'wrapper' as its last child. If 'wrapper' is part of a tree, it's
+// removed from that tree first. It panics if 'wrapper' is 'n' or one of
+// its ancestors, which would make a cycle.
+func (n *Node) Wrap(wrapper *Node) {
+	for a := n; a != nil; a = a.Parent {
+		if a == wrapper {
+			panic("xmlquery: Wrap called with the node or one of its ancestors as wrapper")
+		}
+	}
+	RemoveFromTree(wrapper)
+	wrapper.Parent = n.Parent
+	wrapper.PrevSibling = n.PrevSibling
+	wrapper.NextSibling = n.NextSibling
+	if n.PrevSibling != nil {
+		n.PrevSibling.NextSibling = wrapper
+	} else if n.Parent != nil {
+		n.Parent.FirstChild = wrapper
+	}
+	if n.NextSibling != nil {
+		n.NextSibling.PrevSibling = wrapper
+	} else if n.Parent != nil {
+		n.Parent.LastChild = wrapper
+	}
+	n.Parent, n.PrevSibling, n.NextSibling = nil, nil, nil
+	shiftLevel(wrapper, n.level-wrapper.level)
+	AddChild(wrapper, n)
+	shiftLevel(n, 1)
+}
+
+// Unwrap replaces the node 'n' in its tree by its child nodes. If the node
+// is the root of the tree, then it's no-op.
+func (n *Node) Unwrap() {
+	if n.Parent == nil {
+		return
+	}
+	for child := n.FirstChild; child != nil; {
+		next := child.NextSibling
+		shiftLevel(child, -1)
+		child.Parent = n.Parent
+		child = next
+	}
+	if n.FirstChild != nil {
+		n.FirstChild.PrevSibling = n.PrevSibling
+		n.LastChild.NextSibling = n.NextSibling
+		if n.PrevSibling != nil {
+			n.PrevSibling.NextSibling = n.FirstChild
+		} else {
+			n.Parent.FirstChild = n.FirstChild
+		}
+		if n.NextSibling != nil {
+			n.NextSibling.PrevSibling = n.LastChild
+		} else {
+			n.Parent.LastChild = n.LastChild
+		}
+		n.Parent, n.PrevSibling, n.NextSibling = nil, nil, nil
+		n.FirstChild, n.LastChild = nil, nil
+		return
+	}
+	RemoveFromTree(n)
+}
+
+// shiftLevel adds delta to the level of n and of all its descendants.
+func shiftLevel(n *Node, delta int) {
+	if delta == 0 {
+		return
+	}
+	n.level += delta
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		shiftLevel(child, delta)
+	}
+}
+
+// Equal reports whether the trees rooted at 'a' and 'b' are structurally
+// equal: their nodes have the same types, names, namespaces, attributes (in
+// the same order) and data, and their child nodes are pairwise equal.
+func Equal(a, b *Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Type != b.Type || a.Data != b.Data || a.Prefix != b.Prefix || a.NamespaceURI != b.NamespaceURI || len(a.Attr) != len(b.Attr) {
+		return false
+	}
+	for i := range a.Attr {
+		if a.Attr[i] != b.Attr[i] {
+			return false
+		}
+	}
+	ca, cb := a.FirstChild, b.FirstChild
+	for ; ca != nil && cb != nil; ca, cb = ca.NextSibling, cb.NextSibling {
+		if !Equal(ca, cb) {
+			return false
+		}
+	}
+	return ca == nil && cb == nil
+}
+
+// SameNode reports whether a and b are the same node. Attribute nodes
+// returned by queries are created on each call, so two of them are the
+// same node if they stand for the same attribute of the same element.
+func SameNode(a, b *Node) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return keyOf(a) == keyOf(b)
+}
+
+// Hash returns a 64-bit FNV-1a hash of the subtree rooted at n, covering
+// the type, name, namespace and content of each node and its attributes
+// sorted by name. Trees that are Equal have the same hash, whatever
+// document they belong to, and the hash is stable across runs.
+func (n *Node) Hash() uint64 {
+	h := fnv.New64a()
+	hashNode(h, n)
+	return h.Sum64()
+}
+
+func hashNode(h hash.Hash64, n *Node) {
+	var buf [binary.MaxVarintLen64]byte
+	writeString := func(s string) {
+		h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(s)))])
+		io.WriteString(h, s)
+	}
+	h.Write(buf[:binary.PutUvarint(buf[:], uint64(n.Type))])
+	writeString(n.Data)
+	writeString(n.Prefix)
+	writeString(n.NamespaceURI)
+	attrs := make([]Attr, len(n.Attr))
+	copy(attrs, n.Attr)
+	sort.SliceStable(attrs, func(i, j int) bool {
+		if attrs[i].Name.Space != attrs[j].Name.Space {
+			return attrs[i].Name.Space < attrs[j].Name.Space
+		}
+		return attrs[i].Name.Local < attrs[j].Name.Local
+	})
+	h.Write(buf[:binary.PutUvarint(buf[:], uint64(len(attrs)))])
+	for _, attr := range attrs {
+		writeString(attr.Name.Space)
+		writeString(attr.Name.Local)
+		writeString(attr.NamespaceURI)
+		writeString(attr.Value)
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		h.Write([]byte{1})
+		hashNode(h, child)
+	}
+	h.Write([]byte{0})
+}
+
 // GetRoot returns a root of the tree where 'n' is a node.
 func GetRoot(n *Node) *Node {
 	if n == nil {
@@ -502,3 +2367,108 @@ func GetRoot(n *Node) *Node {
 	}
 	return root
 }
+
+// LCA returns the lowest common ancestor of the nodes a and b, that is the
+// deepest node having both of them in its subtree. It returns a if a is an
+// ancestor of b, or b itself, and nil if they are in different trees.
+func LCA(a, b *Node) *Node {
+	if a == nil || b == nil {
+		return nil
+	}
+	if SameNode(a, b) {
+		return a
+	}
+	ancestors := make(map[*Node]bool)
+	for n := a; n != nil; n = n.Parent {
+		ancestors[n] = true
+	}
+	for n := b; n != nil; n = n.Parent {
+		if ancestors[n] {
+			return n
+		}
+	}
+	return nil
+}
+
+// OwnerDocument returns the DocumentNode at the root of the tree the node
+// belongs to. It returns the node itself if the tree has no document node,
+// such as for a node created or detached from its document.
+func (n *Node) OwnerDocument() *Node {
+	if root := GetRoot(n); root.Type == DocumentNode {
+		return root
+	}
+	return n
+}
+
+// RootNamespace returns the namespace URI of the document element of the
+// tree the node belongs to, or an empty string if there is none.
+func (n *Node) RootNamespace() string {
+	if e := documentElement(n); e != nil {
+		return e.NamespaceURI
+	}
+	return ""
+}
+
+// RootLocalName returns the local name of the document element of the tree
+// the node belongs to, or an empty string if there is none.
+func (n *Node) RootLocalName() string {
+	if e := documentElement(n); e != nil {
+		return e.Data
+	}
+	return ""
+}
+
+// documentElement returns the top-level element of the tree n belongs to,
+// which is the root itself for a tree without a document node.
+func documentElement(n *Node) *Node {
+	root := GetRoot(n)
+	if root.Type == ElementNode {
+		return root
+	}
+	for child := root.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type == ElementNode {
+			return child
+		}
+	}
+	return nil
+}
+
+// IsValidName reports whether s matches the Name production of the XML
+// specification.
+func IsValidName(s string) bool {
+	if s == "" || !utf8.ValidString(s) {
+		return false
+	}
+	for i, r := range s {
+		if !isNameChar(r, i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// IsValidQName reports whether s is a qualified name, prefix:local or
+// local, as defined by the Namespaces in XML specification.
+func IsValidQName(s string) bool {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return IsValidName(s)
+	}
+	prefix, local := s[:i], s[i+1:]
+	return IsValidName(prefix) && IsValidName(local) && !strings.Contains(local, ":")
+}
+
+func isNameChar(r rune, start bool) bool {
+	switch {
+	case r == ':' || r == '_' || 'A' <= r && r <= 'Z' || 'a' <= r && r <= 'z',
+		0xC0 <= r && r <= 0xD6, 0xD8 <= r && r <= 0xF6, 0xF8 <= r && r <= 0x2FF,
+		0x370 <= r && r <= 0x37D, 0x37F <= r && r <= 0x1FFF, 0x200C <= r && r <= 0x200D,
+		0x2070 <= r && r <= 0x218F, 0x2C00 <= r && r <= 0x2FEF, 0x3001 <= r && r <= 0xD7FF,
+		0xF900 <= r && r <= 0xFDCF, 0xFDF0 <= r && r <= 0xFFFD, 0x10000 <= r && r <= 0xEFFFF:
+		return true
+	case start:
+		return false
+	}
+	return r == '-' || r == '.' || '0' <= r && r <= '9' || r == 0xB7 ||
+		0x300 <= r && r <= 0x36F || 0x203F <= r && r <= 0x2040
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"html"
 	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -129,6 +130,414 @@ func TestChildNodes(t *testing.T) {
 
 		testTrue(t, len(children) == 3)
 	})
+
+	t.Run("Mixed content in document order", func(t *testing.T) {
+		doc := loadXML(`<p>Hello <b>big</b><!--c--> <![CDATA[world]]>!</p>`)
+		var types []NodeType
+		for _, child := range FindOne(doc, "//p").ChildNodes() {
+			types = append(types, child.Type)
+		}
+		expected := []NodeType{TextNode, ElementNode, CommentNode, TextNode, CharDataNode, TextNode}
+		if !reflect.DeepEqual(types, expected) {
+			t.Fatalf("expected %v, but got %v", expected, types)
+		}
+	})
+}
+
+func TestFlatten(t *testing.T) {
+	doc := loadXML(`<a x="1"><b id="5">one</b><b><c/></b><d>mixed<e>two</e>tail</d></a>`)
+	expected := []PathValue{
+		{Path: "/a/@x", Value: "1"},
+		{Path: "/a/b[1]/@id", Value: "5"},
+		{Path: "/a/b[1]", Value: "one"},
+		{Path: "/a/d/text()[1]", Value: "mixed"},
+		{Path: "/a/d/e", Value: "two"},
+		{Path: "/a/d/text()[2]", Value: "tail"},
+	}
+	if list := doc.Flatten(); !reflect.DeepEqual(list, expected) {
+		t.Fatalf("expected %v, but got %v", expected, list)
+	}
+	if list := FindOne(doc, "//e").Flatten(); len(list) != 1 || list[0].Path != "/a/d/e" {
+		t.Fatalf("unexpected %v", list)
+	}
+}
+
+func TestXPathAndResolvePath(t *testing.T) {
+	a := loadXML(`<a><b id="1">x</b><b id="2"><c>y</c><!--z--></b></a>`)
+	b := loadXML(`<a><b id="3">u</b><b id="4"><c>v</c><!--w--></b><d/></a>`)
+
+	for _, n := range []*Node{
+		FindOne(a, "/a"),
+		FindOne(a, "//b[2]"),
+		FindOne(a, "//c"),
+		FindOne(a, "//c/text()"),
+		FindOne(a, "//comment()"),
+	} {
+		testTrue(t, ResolvePath(a, n.XPath()) == n)
+	}
+	testValue(t, FindOne(a, "//c").XPath(), "/a/b[2]/c")
+	testValue(t, FindOne(a, "//comment()").XPath(), "/a/b[2]/comment()[1]")
+	testValue(t, a.XPath(), "/")
+	testTrue(t, ResolvePath(a, "/") == a)
+
+	// locate here, apply there
+	testValue(t, ResolvePath(b, FindOne(a, "//c").XPath()).InnerText(), "v")
+	testValue(t, ResolvePath(b, FindOne(a, "//b[2]/@id").XPath()).InnerText(), "4")
+	testValue(t, ResolvePath(b, FindOne(a, "//comment()").XPath()).Data, "w")
+	testTrue(t, ResolvePath(a, "/a/d") == nil)
+	testTrue(t, ResolvePath(a, "/a/b[3]") == nil)
+	testTrue(t, ResolvePath(a, "a/b") == nil)
+
+	// detached trees
+	c := FindOne(b, "//b[2]")
+	RemoveFromTree(c)
+	testValue(t, ResolvePath(c, "/b/c").InnerText(), "v")
+}
+
+func TestEqual(t *testing.T) {
+	a := loadXML(`<a x="1"><b>t</b><!--c--></a>`)
+	testTrue(t, Equal(a, loadXML(`<a x="1"><b>t</b><!--c--></a>`)))
+	testTrue(t, !Equal(a, loadXML(`<a x="2"><b>t</b><!--c--></a>`)))
+	testTrue(t, !Equal(a, loadXML(`<a x="1"><b>u</b><!--c--></a>`)))
+	testTrue(t, !Equal(a, loadXML(`<a x="1"><b>t</b></a>`)))
+	testTrue(t, !Equal(a, nil))
+	testTrue(t, Equal(nil, nil))
+}
+
+func TestHash(t *testing.T) {
+	a := loadXML(`<a x="1" y="2"><b>t</b><!--c--></a>`)
+	testValue(t, a.Hash(), loadXML(`<a x="1" y="2"><b>t</b><!--c--></a>`).Hash())
+	testValue(t, a.Hash(), loadXML(`<a y="2" x="1"><b>t</b><!--c--></a>`).Hash())
+	for _, s := range []string{
+		`<a x="1" y="3"><b>t</b><!--c--></a>`,
+		`<a x="1" y="2"><b>u</b><!--c--></a>`,
+		`<a x="1" y="2"><b>t</b></a>`,
+		`<a x="1" y="2"><b>t</b><!--c--><!----></a>`,
+		`<a x="1" y="2"><b></b>t<!--c--></a>`,
+		`<a x="1" y="2"><b>t</b>c</a>`,
+	} {
+		testTrue(t, a.Hash() != loadXML(s).Hash())
+	}
+	// the same subtree in different documents
+	b1 := FindOne(loadXML(`<r><b id="1"><c/></b></r>`), "//b")
+	b2 := FindOne(loadXML(`<s><t/><b id="1"><c/></b></s>`), "//b")
+	testValue(t, b1.Hash(), b2.Hash())
+	testValue(t, b1.Hash(), b1.Hash())
+}
+
+func TestToMap(t *testing.T) {
+	doc := loadXML(`<shelf>
+		<book id="1"><title>A</title><tag>x</tag><tag>y</tag></book>
+		<book id="2" ns:lang="en" xmlns:ns="urn:ns"><title>B</title><note kind="n">text</note></book>
+		<empty/>
+	</shelf>`)
+	expected := map[string]interface{}{
+		"shelf": map[string]interface{}{
+			"book": []interface{}{
+				map[string]interface{}{
+					"@id":   "1",
+					"title": "A",
+					"tag":   []interface{}{"x", "y"},
+				},
+				map[string]interface{}{
+					"@id":       "2",
+					"@ns:lang":  "en",
+					"@xmlns:ns": "urn:ns",
+					"title":     "B",
+					"note":      map[string]interface{}{"@kind": "n", "#text": "text"},
+				},
+			},
+			"empty": "",
+		},
+	}
+	if m := doc.ToMap(); !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %v, but got %v", expected, m)
+	}
+}
+
+func TestFromMap(t *testing.T) {
+	m := map[string]interface{}{
+		"@xmlns:ns": "urn:ns",
+		"book": []interface{}{
+			map[string]interface{}{"@id": 1, "title": "A", "tag": []interface{}{"x", "y"}},
+			map[string]interface{}{"@id": "2", "@ns:lang": "en", "ns:note": map[string]interface{}{"#text": "a < b"}},
+		},
+		"empty": nil,
+	}
+	n, err := FromMap("shelf", m)
+	testTrue(t, err == nil)
+	testValue(t, n.OutputXML(true), `<shelf xmlns:ns="urn:ns"><book id="1"><tag>x</tag><tag>y</tag><title>A</title></book>`+
+		`<book id="2" ns:lang="en"><ns:note>a &lt; b</ns:note></book><empty></empty></shelf>`)
+	verifyNodePointers(t, n)
+	note := FindOne(n, "//ns:note")
+	testValue(t, note.NamespaceURI, "urn:ns")
+	testValue(t, note.Level(), 2)
+	testValue(t, FindOne(n, "//book[2]").Attr[1].NamespaceURI, "urn:ns")
+
+	// round trip through ToMap
+	doc := loadXML(n.OutputXML(true))
+	if back := FindOne(doc, "/shelf").ToMap(); !reflect.DeepEqual(back, FindOne(n, "/self::shelf").ToMap()) {
+		t.Fatalf("expected %v, but got %v", n.ToMap(), back)
+	}
+
+	for _, test := range []struct {
+		name string
+		m    map[string]interface{}
+	}{
+		{"1a", nil},
+		{"a", map[string]interface{}{"@1x": "v"}},
+		{"a", map[string]interface{}{"@x": []interface{}{"v"}}},
+		{"a", map[string]interface{}{"b": struct{}{}}},
+		{"a", map[string]interface{}{"#text": map[string]interface{}{}}},
+	} {
+		_, err := FromMap(test.name, test.m)
+		testTrue(t, err != nil)
+	}
+}
+
+func TestNormalizedText(t *testing.T) {
+	doc := loadXML("<p>\n\t Hello,  <b>big\r\n world</b><!-- x -->\u00a0! </p>")
+	testValue(t, FindOne(doc, "//p").NormalizedText(), "Hello, big world\u00a0!")
+	testValue(t, loadXML("<p> </p>").NormalizedText(), "")
+}
+
+func TestFindTextNodes(t *testing.T) {
+	doc := loadXML(`<a>foo<b>bar<![CDATA[foobar]]></b><!--foo--><c>baz</c></a>`)
+	list := doc.FindTextNodes(func(s string) bool {
+		return strings.Contains(s, "foo")
+	})
+	testValue(t, len(list), 2)
+	testValue(t, list[0].Data, "foo")
+	testValue(t, list[1].Type, CharDataNode)
+	testValue(t, list[1].Parent.Data, "b")
+}
+
+func TestLang(t *testing.T) {
+	doc := loadXML(`<a xml:lang="en"><b><c>hi</c></b><b xml:lang="de-AT"><c>servus</c></b><b xml:lang=""><c/></b></a>`)
+	list := Find(doc, "//c")
+	testValue(t, list[0].Lang(), "en")
+	testValue(t, list[1].Lang(), "de-AT")
+	testValue(t, list[2].Lang(), "")
+	testTrue(t, list[0].IsLang("EN"))
+	testTrue(t, list[1].IsLang("de"))
+	testTrue(t, !list[1].IsLang("d"))
+	testTrue(t, !list[2].IsLang("en"))
+	testValue(t, doc.Lang(), "")
+
+	// lang() isn't provided by the xpath package, IsLang is its replacement.
+	if _, err := QueryAll(doc, "//c[lang('en')]"); err == nil {
+		t.Fatal("lang() is now supported by xpath, test it directly")
+	}
+}
+
+func TestIsEmptyAndHasContent(t *testing.T) {
+	doc := loadXML(`<a><b/><c> </c><d><!--x--></d><e>t</e><f><g/></f><h><![CDATA[y]]></h></a>`)
+	for _, test := range []struct {
+		name       string
+		empty      bool
+		hasContent bool
+	}{
+		{"b", true, false},
+		{"c", false, false},
+		{"d", false, false},
+		{"e", false, true},
+		{"f", false, true},
+		{"h", false, true},
+	} {
+		n := FindOne(doc, "//"+test.name)
+		testValue(t, n.IsEmpty(), test.empty)
+		testValue(t, n.HasContent(), test.hasContent)
+	}
+}
+
+func TestSetText(t *testing.T) {
+	doc := loadXML(`<a><b>1</b>text<!--c--><d/></a>`)
+	a := FindOne(doc, "//a")
+	b := FindOne(a, "b")
+	a.SetText("x < y")
+	verifyNodePointers(t, doc)
+	testTrue(t, a.FirstChild == a.LastChild)
+	testValue(t, a.FirstChild.Type, TextNode)
+	testValue(t, a.FirstChild.Level(), a.Level()+1)
+	testTrue(t, b.Parent == nil && b.NextSibling == nil)
+	testValue(t, a.InnerText(), "x < y")
+	testValue(t, a.OutputXML(true), `<a>x &lt; y</a>`)
+}
+
+func TestChildElementAt(t *testing.T) {
+	doc := loadXML(`<a> <!--c--><b/>text<c/> <d/></a>`)
+	a := FindOne(doc, "/a")
+	testValue(t, a.ChildElementCount(), 3)
+	testValue(t, a.ChildElementAt(0).Data, "b")
+	testValue(t, a.ChildElementAt(2).Data, "d")
+	testTrue(t, a.ChildElementAt(3) == nil)
+	testTrue(t, a.ChildElementAt(-1) == nil)
+	testValue(t, FindOne(doc, "//b").ChildElementCount(), 0)
+}
+
+func TestNextOfType(t *testing.T) {
+	doc := loadXML(`<a><!--1--><b/>text<![CDATA[x]]><!--2--><c/></a>`)
+	b := FindOne(doc, "//b")
+	testValue(t, b.NextOfType(CommentNode).Data, "2")
+	testValue(t, b.PrevOfType(CommentNode).Data, "1")
+	testValue(t, b.NextOfType(CharDataNode).Data, "x")
+	testValue(t, b.NextOfType(ElementNode).Data, "c")
+	testTrue(t, b.PrevOfType(ElementNode) == nil)
+	testTrue(t, FindOne(doc, "//c").NextOfType(CommentNode) == nil)
+}
+
+func TestElementNames(t *testing.T) {
+	doc := loadXML(`<a xmlns:x="urn:x"><b><c/></b><x:b/><c/><d>text</d></a>`)
+	testTrue(t, reflect.DeepEqual(doc.ElementNames(), []string{"a", "b", "c", "d"}))
+	testTrue(t, reflect.DeepEqual(FindOne(doc, "//d").ElementNames(), []string(nil)))
+
+	names := FindOne(doc, "/a").ElementExpandedNames()
+	expected := []xml.Name{{Local: "b"}, {Local: "c"}, {Space: "urn:x", Local: "b"}, {Local: "d"}}
+	testTrue(t, reflect.DeepEqual(names, expected))
+}
+
+func TestPIAttributes(t *testing.T) {
+	doc := loadXML(`<?xml version="1.0"?><?xml-stylesheet type="text/xsl"  href='s.xsl' title="My style"?><?pi not pseudo attributes?><a/>`)
+	pi := doc.FirstChild.NextSibling
+	testTrue(t, reflect.DeepEqual(pi.PIAttributes(), map[string]string{"type": "text/xsl", "href": "s.xsl", "title": "My style"}))
+	testValue(t, len(pi.NextSibling.PIAttributes()), 0)
+	testTrue(t, FindOne(doc, "/a").PIAttributes() == nil)
+}
+
+func TestTruncate(t *testing.T) {
+	doc := loadXML(`<a id="1"><b><c><d/></c><e/></b><f>text</f></a>`)
+	a := FindOne(doc, "/a")
+	testValue(t, a.Truncate(1).OutputXML(true), `<a id="1"><b><!-- 3 nodes elided --></b><f><!-- 1 node elided --></f></a>`)
+	testValue(t, a.Truncate(2).OutputXML(true), `<a id="1"><b><c><!-- 1 node elided --></c><e></e></b><f>text</f></a>`)
+	testValue(t, a.Truncate(0).OutputXML(true), `<a id="1"><!-- 6 nodes elided --></a>`)
+	testValue(t, a.Truncate(10).OutputXML(true), a.OutputXML(true))
+
+	truncated := a.Truncate(1)
+	testTrue(t, truncated.Parent == nil && truncated.FirstChild != a.FirstChild)
+	verifyNodePointers(t, truncated)
+}
+
+func TestLCA(t *testing.T) {
+	doc := loadXML(`<a><b><c/><d><e/></d></b><f/></a>`)
+	b, c, e, f := FindOne(doc, "//b"), FindOne(doc, "//c"), FindOne(doc, "//e"), FindOne(doc, "//f")
+	testTrue(t, LCA(c, e) == b)
+	testTrue(t, LCA(e, f).Data == "a")
+	testTrue(t, LCA(b, e) == b)
+	testTrue(t, LCA(e, b) == b)
+	testTrue(t, LCA(c, c) == c)
+	testTrue(t, LCA(nil, c) == nil)
+	testTrue(t, LCA(c, &Node{Type: ElementNode}) == nil)
+}
+
+func TestOutputWithoutRedundantNamespaces(t *testing.T) {
+	doc := loadXML(`<a xmlns="urn:a" xmlns:x="urn:x"><x:b xmlns:x="urn:x" xmlns="urn:a"><c xmlns=""><d xmlns=""/></c></x:b><x:e xmlns:x="urn:other"><x:f xmlns:x="urn:other"/></x:e></a>`)
+	a := FindOne(doc, "/*")
+	testValue(t, a.OutputXMLWithOptions(WithOutputSelf(), WithoutRedundantNamespaces()),
+		`<a xmlns="urn:a" xmlns:x="urn:x"><x:b><c xmlns=""><d></d></c></x:b><x:e xmlns:x="urn:other"><x:f></x:f></x:e></a>`)
+
+	// the declarations of the ancestors of the output are not in scope
+	b := a.FirstChild
+	testValue(t, b.OutputXMLWithOptions(WithOutputSelf(), WithoutRedundantNamespaces()),
+		`<x:b xmlns:x="urn:x" xmlns="urn:a"><c xmlns=""><d></d></c></x:b>`)
+	testValue(t, b.FirstChild.OutputXMLWithOptions(WithOutputSelf(), WithoutRedundantNamespaces()), `<c><d></d></c>`)
+}
+
+func TestCreateNodes(t *testing.T) {
+	root := CreateElement("x", "root", "urn:x")
+	AddAttr(root, "xmlns:x", "urn:x")
+	item := CreateElement("", "item", "")
+	AddChild(root, item)
+	AddChild(item, CreateText("a < b"))
+	AddChild(root, CreateComment(" note "))
+	AddChild(root, CreateCData("<raw>"))
+	testValue(t, root.OutputXML(true), `<x:root xmlns:x="urn:x"><item>a &lt; b</item><!-- note --><![CDATA[<raw>]]></x:root>`)
+	testTrue(t, FindOne(root, "self::*[namespace-uri()='urn:x']") == root)
+	verifyNodePointers(t, root)
+}
+
+func TestIndexPath(t *testing.T) {
+	doc := loadXML(`<a><!--c--><b/>text<c><d/><e/></c></a>`)
+	e := FindOne(doc, "//e")
+	// the XML declaration added by the parser comes first
+	testTrue(t, reflect.DeepEqual(e.IndexPath(), []int{1, 3, 1}))
+	testTrue(t, NodeAtIndexPath(doc, e.IndexPath()) == e)
+	testTrue(t, NodeAtIndexPath(FindOne(doc, "//d"), []int{1, 3, 1}) == e)
+	testTrue(t, reflect.DeepEqual(doc.IndexPath(), []int{}))
+	testTrue(t, NodeAtIndexPath(doc, nil) == doc)
+	for _, n := range doc.Descendants() {
+		testTrue(t, NodeAtIndexPath(doc, n.IndexPath()) == n)
+	}
+	testTrue(t, NodeAtIndexPath(doc, []int{1, 4}) == nil)
+	testTrue(t, NodeAtIndexPath(doc, []int{1, 1, 0}) == nil)
+	testTrue(t, NodeAtIndexPath(doc, []int{-1}) == nil)
+	testTrue(t, loadXML(`<a x="1"/>`).SelectElement("a").attributeNode(0).IndexPath() == nil)
+}
+
+func TestRename(t *testing.T) {
+	doc := loadXML(`<a xmlns="urn:a" xmlns:x="urn:x"><b/></a>`)
+	b := FindOne(doc, "//*[local-name()='b']")
+	b.Rename("x:c")
+	testValue(t, b.Prefix, "x")
+	testValue(t, b.Data, "c")
+	testValue(t, b.NamespaceURI, "urn:x")
+	b.Rename("d")
+	testValue(t, b.Prefix, "")
+	testValue(t, b.NamespaceURI, "urn:a")
+	testValue(t, doc.OutputXML(false), `<?xml version="1.0"?><a xmlns="urn:a" xmlns:x="urn:x"><d></d></a>`)
+}
+
+func TestOutputWithMaxLineWidth(t *testing.T) {
+	doc := loadXML(`<svg width="100" height="100" viewBox="0 0 100 100"><circle cx="50" cy="50" r="40" stroke="green" fill="yellow"/><g id="layer"/></svg>`)
+	svg := FindOne(doc, "/svg")
+	testValue(t, svg.OutputXMLWithOptions(WithOutputSelf(), WithIndentation("\t"), WithMaxLineWidth(40)), `
+<svg
+	width="100"
+	height="100"
+	viewBox="0 0 100 100">
+	<circle
+		cx="50"
+		cy="50"
+		r="40"
+		stroke="green"
+		fill="yellow"></circle>
+	<g id="layer"></g>
+</svg>`)
+	testValue(t, svg.OutputXMLWithOptions(WithOutputSelf(), WithEmptyTagSupport(), WithMaxLineWidth(54)), `<svg width="100" height="100" viewBox="0 0 100 100"><circle
+  cx="50"
+  cy="50"
+  r="40"
+  stroke="green"
+  fill="yellow"/><g id="layer"/></svg>`)
+	testValue(t, svg.OutputXMLWithOptions(WithOutputSelf(), WithMaxLineWidth(0)), svg.OutputXML(true))
+}
+
+func TestAppendXML(t *testing.T) {
+	doc := loadXML(`<list xmlns:x="urn:x"><item>1</item></list>`)
+	list := FindOne(doc, "/list")
+	err := list.AppendXML(`<item>2</item><!--c--><x:item a="b">3</x:item>`)
+	testTrue(t, err == nil)
+	testValue(t, list.OutputXML(true), `<list xmlns:x="urn:x"><item>1</item><item>2</item><!--c--><x:item a="b">3</x:item></list>`)
+	verifyNodePointers(t, doc)
+	testValue(t, FindOne(doc, "//*[namespace-uri()='urn:x']").InnerText(), "3")
+	testValue(t, FindOne(doc, "//item[2]").Level(), 2)
+
+	testTrue(t, list.AppendXML(`<item>`) != nil)
+	testTrue(t, list.AppendXML(`<y:item/>`) != nil)
+	testValue(t, len(list.SelectElements("*")), 3)
+}
+
+func TestFrequency(t *testing.T) {
+	doc := loadXML(`<a xmlns:x="urn:x" id="1"><b id="2" x:id="3"/><b class="c"/><x:c id="4">text<b/></x:c></a>`)
+	testTrue(t, reflect.DeepEqual(doc.AttributeFrequency(), map[string]int{"id": 3, "x:id": 1, "class": 1}))
+	testTrue(t, reflect.DeepEqual(doc.ElementFrequency(), map[string]int{"a": 1, "b": 3, "x:c": 1}))
+
+	// the current node is not counted
+	a := FindOne(doc, "/a")
+	testTrue(t, reflect.DeepEqual(a.AttributeFrequency(), map[string]int{"id": 2, "x:id": 1, "class": 1}))
+	testTrue(t, reflect.DeepEqual(a.ElementFrequency(), map[string]int{"b": 3, "x:c": 1}))
+	c := FindOne(doc, "//*[local-name()='c']")
+	testTrue(t, reflect.DeepEqual(c.AttributeFrequency(), map[string]int{}))
+	testTrue(t, reflect.DeepEqual(c.ElementFrequency(), map[string]int{"b": 1}))
 }
 
 func TestAddAttr(t *testing.T) {
@@ -173,6 +582,124 @@ func TestAddAttr(t *testing.T) {
 	}
 }
 
+func TestFindWithAttr(t *testing.T) {
+	doc := loadXML(`<a id="0" xmlns:x="urn:x"><b id="1"><c id=""/></b><d x:id="2"/><e/></a>`)
+	list := doc.FindWithAttr("id")
+	testValue(t, len(list), 3)
+	testValue(t, list[0].Data, "a")
+	testValue(t, list[2].Data, "c")
+	list = doc.FindWithAttr("x:id")
+	testValue(t, len(list), 1)
+	testValue(t, list[0].Data, "d")
+	testValue(t, len(FindOne(doc, "//b").FindWithAttr("id")), 1)
+}
+
+func TestDescendants(t *testing.T) {
+	doc := loadXML(`<a><b>x<c/></b><!--d--><e/></a>`)
+	var s []string
+	for _, n := range FindOne(doc, "/a").Descendants() {
+		s = append(s, n.Data)
+	}
+	testValue(t, strings.Join(s, ","), "b,x,c,d,e")
+	testValue(t, len(FindOne(doc, "//c").Descendants()), 0)
+	testValue(t, len(FindOne(doc, "//b").Descendants()), 2)
+}
+
+func TestReplaceText(t *testing.T) {
+	doc := loadXML(`<msg to="{{name}}">Dear {{name}},<p>{{name}}{{name}} <![CDATA[{{name}}]]></p><!--{{name}}--></msg>`)
+	msg := FindOne(doc, "/msg")
+	testValue(t, msg.ReplaceText("{{name}}", "Ann"), 4)
+	testValue(t, msg.OutputXML(true), `<msg to="{{name}}">Dear Ann,<p>AnnAnn <![CDATA[Ann]]></p><!--{{name}}--></msg>`)
+	testValue(t, msg.ReplaceText("{{name}}", "Ann"), 0)
+	testValue(t, msg.ReplaceText("", "x"), 0)
+
+	doc = loadXML(`<p>Call 555-1234 or 555-9876.<b>555-0000</b></p>`)
+	re := regexp.MustCompile(`(\d{3})-\d{4}`)
+	testValue(t, doc.ReplaceTextRegexp(re, "$1-XXXX"), 3)
+	testValue(t, FindOne(doc, "/p").InnerText(), "Call 555-XXXX or 555-XXXX.555-XXXX")
+}
+
+func TestComments(t *testing.T) {
+	doc := loadXML(`<?xml version="1.0"?><!--first--><a><!-- @deprecated --><b>x<!--inner--></b></a><!--last-->`)
+	testTrue(t, reflect.DeepEqual(doc.CommentText(), []string{"first", " @deprecated ", "inner", "last"}))
+	list := FindOne(doc, "//b").Comments()
+	testValue(t, len(list), 1)
+	testValue(t, list[0].Data, "inner")
+	testTrue(t, FindOne(doc, "//b").FirstChild.CommentText() == nil)
+}
+
+func TestInNamespace(t *testing.T) {
+	doc := loadXML(`<html xmlns="http://www.w3.org/1999/xhtml"><body><p>x</p>` +
+		`<svg xmlns="http://www.w3.org/2000/svg"><g><circle/><foreignObject><div xmlns="http://www.w3.org/1999/xhtml"/></foreignObject></g></svg>` +
+		`</body></html>`)
+	names := func(list []*Node) string {
+		var s []string
+		for _, n := range list {
+			s = append(s, n.Data)
+		}
+		return strings.Join(s, ",")
+	}
+	testValue(t, names(doc.InNamespace("http://www.w3.org/2000/svg")), "svg,g,circle,foreignObject")
+	testValue(t, names(doc.InNamespace("http://www.w3.org/1999/xhtml")), "html,body,p,div")
+	testValue(t, names(FindOne(doc, "//*[local-name()='g']").InNamespace("http://www.w3.org/2000/svg")), "circle,foreignObject")
+	testValue(t, len(doc.InNamespace("")), 0)
+}
+
+func TestMatchNamespace(t *testing.T) {
+	testTrue(t, MatchNamespace("http://example.com/ns", "http://example.com/ns", NSMatchOptions{}))
+	testTrue(t, !MatchNamespace("http://example.com/ns", "http://example.com/ns/", NSMatchOptions{}))
+	testTrue(t, MatchNamespace("http://example.com/ns", "http://example.com/ns/", NSMatchOptions{IgnoreTrailingSlash: true}))
+	testTrue(t, !MatchNamespace("HTTP://Example.COM/ns", "http://example.com/ns", NSMatchOptions{}))
+	testTrue(t, MatchNamespace("HTTP://Example.COM/ns", "http://example.com/ns", NSMatchOptions{IgnoreSchemeHostCase: true}))
+	testTrue(t, !MatchNamespace("http://example.com/NS", "http://example.com/ns", NSMatchOptions{IgnoreSchemeHostCase: true}))
+	testTrue(t, MatchNamespace("URN:isbn:X", "urn:isbn:X", NSMatchOptions{IgnoreSchemeHostCase: true}))
+	testTrue(t, MatchNamespace("HTTP://Example.COM/", "http://example.com", NSMatchOptions{IgnoreTrailingSlash: true, IgnoreSchemeHostCase: true}))
+
+	doc := loadXML(`<a xmlns="http://example.com/ns"><b xmlns="http://example.com/ns/"/></a>`)
+	testValue(t, len(doc.InNamespace("http://example.com/ns")), 1)
+	testValue(t, len(doc.InNamespaceWithOptions("http://example.com/ns", NSMatchOptions{IgnoreTrailingSlash: true})), 2)
+}
+
+func TestCountElements(t *testing.T) {
+	testValue(t, doc.CountElements("book"), 3)
+	testValue(t, doc.CountElements("title"), 3)
+	testValue(t, FindOne(doc, "//book").CountElements("title"), 1)
+	testValue(t, FindOne(doc, "//book").CountElements("book"), 0)
+	testValue(t, doc.CountElements("magazine"), 0)
+	n := loadXML(`<a xmlns:x="urn:x"><x:b/><b><b/></b></a>`)
+	testValue(t, n.CountElements("b"), 3)
+}
+
+func TestFirstElementText(t *testing.T) {
+	testValue(t, doc.FirstElementText("title"), "XML Developer's Guide")
+	testValue(t, FindOne(doc, "//book[3]").FirstElementText("title"), "Maeve Ascendant")
+	testValue(t, doc.FirstElementText("magazine"), "")
+	testValue(t, doc.FirstElementText("book[2]"), "")
+	n := loadXML(`<a xmlns:x="urn:x"><b><x:c>1</x:c></b><c>2</c></a>`)
+	testValue(t, n.FirstElementText("c"), "1")
+}
+
+func TestFollowingAndPrecedingElements(t *testing.T) {
+	doc := loadXML(`<a><b><c/><d id="1"><e/></d></b><f><g/></f><h/></a>`)
+	names := func(list []*Node) string {
+		var s []string
+		for _, n := range list {
+			s = append(s, n.Data)
+		}
+		return strings.Join(s, ",")
+	}
+	d := FindOne(doc, "//d")
+	testValue(t, names(d.FollowingElements()), "f,g,h")
+	testValue(t, names(d.PrecedingElements()), "c")
+	testValue(t, names(FindOne(doc, "//g").PrecedingElements()), "b,c,d,e")
+	testValue(t, names(FindOne(doc, "//d/@id").FollowingElements()), "e,f,g,h")
+	testValue(t, names(FindOne(doc, "//a").FollowingElements()), "")
+	for _, n := range Find(doc, "//*") {
+		testValue(t, names(n.FollowingElements()), names(Find(n, "following::*")))
+		testValue(t, names(n.PrecedingElements()), names(documentOrder(Find(n, "preceding::*"))))
+	}
+}
+
 func TestSetAttr(t *testing.T) {
 	for _, test := range []struct {
 		name     string
@@ -270,6 +797,26 @@ func TestRemoveAttr(t *testing.T) {
 	}
 }
 
+func TestNodesBetween(t *testing.T) {
+	doc := loadXML(`<a><h1/>x<p/><h1/><p/><h1/><b><p/></b></a>`)
+	marks := Find(doc, "//h1")
+
+	list, err := NodesBetween(marks[0], marks[1])
+	testTrue(t, err == nil)
+	testValue(t, len(list), 4)
+	testTrue(t, list[0] == marks[0] && list[3] == marks[1])
+	testValue(t, list[1].Data, "x")
+
+	list, err = NodesBetween(marks[2], marks[2])
+	testTrue(t, err == nil)
+	testValue(t, len(list), 1)
+
+	_, err = NodesBetween(marks[1], marks[0])
+	testTrue(t, err != nil)
+	_, err = NodesBetween(marks[0], FindOne(doc, "//b/p"))
+	testTrue(t, err != nil)
+}
+
 func TestRemoveFromTree(t *testing.T) {
 	xmlStr := `<?procinst?>
 		<!--comment-->
@@ -376,6 +923,91 @@ func TestAddImmediateSibling(t *testing.T) {
 	testValue(t, root.OutputXMLWithOptions(WithoutPreserveSpace()), `<?xml version="1.0" encoding="UTF-8"?><AAA><BBB id="1"></BBB><r></r><CCC id="2"><DDD></DDD></CCC><CCC id="3"><DDD></DDD></CCC></AAA>`)
 }
 
+func TestWrap(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		xpath    string
+		expected string
+	}{
+		{"only child", "//c", `<a><b><w><c></c></w></b><d></d><e></e></a>`},
+		{"first child", "//b", `<a><w><b><c></c></b></w><d></d><e></e></a>`},
+		{"middle child", "//d", `<a><b><c></c></b><w><d></d></w><e></e></a>`},
+		{"last child", "//e", `<a><b><c></c></b><d></d><w><e></e></w></a>`},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			doc := loadXML(`<a><b><c/></b><d/><e/></a>`)
+			n := FindOne(doc, test.xpath)
+			level := n.Level()
+			w := &Node{Type: ElementNode, Data: "w"}
+			n.Wrap(w)
+			verifyNodePointers(t, doc)
+			testValue(t, doc.OutputXML(false), `<?xml version="1.0"?>`+test.expected)
+			testValue(t, w.Level(), level)
+			testValue(t, n.Level(), level+1)
+
+			w.Unwrap()
+			verifyNodePointers(t, doc)
+			testValue(t, doc.OutputXML(false), `<?xml version="1.0"?><a><b><c></c></b><d></d><e></e></a>`)
+			testValue(t, n.Level(), level)
+			testTrue(t, w.Parent == nil && w.FirstChild == nil)
+		})
+	}
+
+	t.Run("wrap in itself or an ancestor", func(t *testing.T) {
+		doc := loadXML(`<a><b><c/></b></a>`)
+		c := FindOne(doc, "//c")
+		for _, wrapper := range []*Node{c, c.Parent, doc} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatalf("Wrap should panic for the wrapper %s", wrapper.Data)
+					}
+				}()
+				c.Wrap(wrapper)
+			}()
+		}
+		verifyNodePointers(t, doc)
+		testValue(t, FindOne(doc, "/a").OutputXML(true), `<a><b><c></c></b></a>`)
+	})
+
+	t.Run("unwrap multiple children", func(t *testing.T) {
+		doc := loadXML(`<a><x/><b>1<c/>2</b><y/></a>`)
+		FindOne(doc, "//b").Unwrap()
+		verifyNodePointers(t, doc)
+		testValue(t, FindOne(doc, "/a").OutputXML(true), `<a><x></x>1<c></c>2<y></y></a>`)
+	})
+}
+
+func TestAppendChildren(t *testing.T) {
+	a := loadXML(`<a><x/></a>`)
+	b := loadXML(`<b>1<y id="2"><z/></y></b>`)
+	dst, src := FindOne(a, "/a"), FindOne(b, "/b")
+
+	dst.AppendChildren(src)
+	verifyNodePointers(t, a)
+	verifyNodePointers(t, b)
+	testTrue(t, src.FirstChild == nil && src.LastChild == nil)
+	testValue(t, dst.OutputXML(true), `<a><x></x>1<y id="2"><z></z></y></a>`)
+	testValue(t, FindOne(a, "//z").Level(), 3)
+}
+
+func TestCopyChildrenFrom(t *testing.T) {
+	a := loadXML(`<a><x/></a>`)
+	b := loadXML(`<root><b>1<y id="2"><z/></y></b></root>`)
+	dst, src := FindOne(a, "/a"), FindOne(b, "//b")
+
+	dst.CopyChildrenFrom(src)
+	verifyNodePointers(t, a)
+	testValue(t, dst.OutputXML(true), `<a><x></x>1<y id="2"><z></z></y></a>`)
+	testValue(t, src.OutputXML(true), `<b>1<y id="2"><z></z></y></b>`)
+	testValue(t, FindOne(a, "//z").Level(), 3)
+
+	// the copies are independent of the originals
+	FindOne(a, "//y").SetAttr("id", "3")
+	testValue(t, FindOne(b, "//y").SelectAttr("id"), "2")
+	testTrue(t, FindOne(b, "//z").Parent == FindOne(b, "//y"))
+}
+
 func TestSelectElement(t *testing.T) {
 	s := `<?xml version="1.0" encoding="UTF-8"?>
     <AAA>
@@ -564,6 +1196,30 @@ func TestWriteWithNamespacePrefix(t *testing.T) {
 	}
 }
 
+func TestOutputXMLWithNamespacePrefixes(t *testing.T) {
+	s := `<root xmlns:a="urn:x"><a:item/><child xmlns:b="urn:x"><b:item b:id="1"/></child><other xmlns:c="urn:y"><c:z/></other></root>`
+	doc, _ := Parse(strings.NewReader(s))
+	root := FindOne(doc, "/root")
+
+	expected := `<root xmlns:x="urn:x"><x:item></x:item><child><x:item x:id="1"></x:item></child><other xmlns:c="urn:y"><c:z></c:z></other></root>`
+	output := root.OutputXMLWithOptions(WithOutputSelf(), WithNamespacePrefixes(map[string]string{"urn:x": "x"}))
+	testValue(t, output, expected)
+	doc2, err := Parse(strings.NewReader(output))
+	testTrue(t, err == nil)
+	testValue(t, FindOne(doc2, "//child/*").NamespaceURI, "urn:x")
+	testValue(t, FindOne(doc2, "//child/*").Attr[0].NamespaceURI, "urn:x")
+
+	// the configured namespaces are only declared if used
+	expected = `<child xmlns:x="urn:x"><x:item x:id="1"></x:item></child>`
+	output = FindOne(doc, "//child").OutputXMLWithOptions(WithOutputSelf(), WithNamespacePrefixes(map[string]string{"urn:x": "x", "urn:y": "y"}))
+	testValue(t, output, expected)
+
+	// mapping to the default namespace
+	expected = `<root xmlns="urn:x" xmlns:a="urn:x"><item></item><child xmlns:b="urn:x"><item b:id="1"></item></child><other xmlns:c="urn:y"><c:z></c:z></other></root>`
+	output = root.OutputXMLWithOptions(WithOutputSelf(), WithNamespacePrefixes(map[string]string{"urn:x": ""}))
+	testValue(t, output, expected)
+}
+
 func TestQueryWithPrefix(t *testing.T) {
 	s := `<?xml version="1.0" encoding="UTF-8"?><S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/"><S:Body test="1"><ns2:Fault xmlns:ns2="http://schemas.xmlsoap.org/soap/envelope/" xmlns:ns3="http://www.w3.org/2003/05/soap-envelope"><faultcode>ns2:Client</faultcode><faultstring>This is a client fault</faultstring></ns2:Fault></S:Body></S:Envelope>`
 	doc, _ := Parse(strings.NewReader(s))
@@ -606,6 +1262,25 @@ func TestOutputXMLWithCommentNode(t *testing.T) {
 	}
 }
 
+func TestCommentRoundTrip(t *testing.T) {
+	s := `<?xml version="1.0"?><!--top--><a><!--first--><b>x<!--inside-->y</b><!--between--><c></c><!-- last --></a><!--after-->`
+	doc, err := Parse(strings.NewReader(s))
+	testTrue(t, err == nil)
+	testValue(t, doc.OutputXML(false), s)
+
+	comments := Find(doc, "//comment()")
+	testValue(t, len(comments), 6)
+	for _, n := range comments {
+		testTrue(t, n.IsComment())
+	}
+	testValue(t, comments[2].Data, "inside")
+	testValue(t, comments[2].PrevSibling.Data, "x")
+	testValue(t, comments[3].PrevSibling.Data, "b")
+	testValue(t, comments[3].NextSibling.Data, "c")
+	testValue(t, comments[4].Data, " last ")
+	testTrue(t, !FindOne(doc, "//b").IsComment())
+}
+
 func TestOutputXMLWithSpaceParent(t *testing.T) {
 	s := `<?xml version="1.0" encoding="utf-8"?>
 	<class_list>
@@ -725,6 +1400,26 @@ func TestOutputXMLWithDefaultOptions(t *testing.T) {
 	}
 }
 
+func TestSerializeNodes(t *testing.T) {
+	doc := loadXML(`<a><b id="1">x</b><!-- c --><b id="2"><c/></b></a>`)
+	testValue(t, SerializeNodes(Find(doc, "//b")), `<b id="1">x</b><b id="2"><c></c></b>`)
+	testValue(t, SerializeNodes(nil), "")
+
+	var b strings.Builder
+	err := WriteNodes(&b, Find(doc, "/a/node()"), WithoutComments(), WithEmptyTagSupport())
+	testTrue(t, err == nil)
+	testValue(t, b.String(), `<b id="1">x</b><b id="2"><c/></b>`)
+}
+
+func TestOutputWithSortedAttributes(t *testing.T) {
+	doc := loadXML(`<a z="1" xmlns:y="urn:b" y:b="2" xmlns="urn:d" xmlns:x="urn:a" x:c="3" a="4" y:a="5"><b q="1" p="2"/></a>`)
+	a := FindOne(doc, "/*")
+	testValue(t, a.OutputXMLWithOptions(WithOutputSelf(), WithSortedAttributes()),
+		`<a xmlns="urn:d" xmlns:x="urn:a" xmlns:y="urn:b" a="4" z="1" x:c="3" y:a="5" y:b="2"><b p="2" q="1"></b></a>`)
+	// the tree itself is unchanged
+	testValue(t, a.Attr[0].Name.Local, "z")
+}
+
 func TestOutputXMLWithOptions(t *testing.T) {
 	s := `<?xml version="1.0" encoding="utf-8"?><node><empty></empty></node>`
 	expected := `<?xml version="1.0" encoding="utf-8"?><node><empty/></node>`
@@ -737,6 +1432,17 @@ func TestOutputXMLWithOptions(t *testing.T) {
 	}
 }
 
+func TestOutputXMLWithNonSelfClosing(t *testing.T) {
+	s := `<html><br></br><script src="a.js"></script><p><br/></p></html>`
+	expected := `<html><br/><script src="a.js"></script><p><br/></p></html>`
+
+	doc, _ := Parse(strings.NewReader(s))
+	result := FindOne(doc, "/html").OutputXMLWithOptions(WithOutputSelf(), WithEmptyTagSupport(), WithNonSelfClosing("script"))
+	if result != expected {
+		t.Errorf("output was not expected. expected %v but got %v", expected, result)
+	}
+}
+
 func TestOutputXMLWithPreserveSpaceOption(t *testing.T) {
 	s := `<?xml version="1.0" encoding="utf-8"?>
 	<class_list>
@@ -772,6 +1478,38 @@ func TestOutputXMLWithIndentation(t *testing.T) {
 	}
 }
 
+func TestOutputXMLWithNewLine(t *testing.T) {
+	doc, _ := Parse(strings.NewReader(`<a><b>1</b><!DOCTYPE x><c/></a>`))
+	output := FindOne(doc, "/a").OutputXMLWithOptions(WithOutputSelf(), WithIndentation("\t"), WithNewLine("\r\n"))
+	testValue(t, output, "\r\n<a>\r\n\t<b>1</b>\r\n<!DOCTYPE x>\r\n\t<c></c>\r\n</a>")
+	testTrue(t, !strings.Contains(strings.ReplaceAll(output, "\r\n", ""), "\n"))
+
+	// the line break is only used with indentation
+	output = FindOne(doc, "/a").OutputXMLWithOptions(WithOutputSelf(), WithNewLine("\r\n"))
+	testValue(t, output, "<a><b>1</b><!DOCTYPE x><c></c></a>")
+}
+
+func TestOutputXMLWithIndentationMixedContent(t *testing.T) {
+	s := `<doc><p>Hello <b>bold</b> world</p><list><item>1</item><item>x<i>y<u>z</u></i></item></list></doc>`
+	expected := `<?xml version="1.0"?>
+<doc>
+  <p>Hello <b>bold</b> world</p>
+  <list>
+    <item>1</item>
+    <item>x<i>y<u>z</u></i></item>
+  </list>
+</doc>`
+
+	doc, _ := Parse(strings.NewReader(s))
+	output := doc.OutputXMLWithOptions(WithIndentation("  "))
+	if output != expected {
+		t.Errorf("output was not expected. expected %v but got %v", expected, output)
+	}
+	doc2, _ := Parse(strings.NewReader(output))
+	testValue(t, FindOne(doc2, "//p").InnerText(), "Hello bold world")
+	testValue(t, FindOne(doc2, "//item[2]").InnerText(), "xyz")
+}
+
 func TestNodeLevel(t *testing.T) {
 	s := `<?xml version="1.0" encoding="utf-8"?>
 	<class_list>
@@ -812,3 +1550,104 @@ func TestOutputXMLWithSingleQuotes(t *testing.T) {
 		t.Errorf(`expected "%s", obtained "%s"`, expected, output)
 	}
 }
+
+func TestEscapeText(t *testing.T) {
+	testValue(t, EscapeText(`a < b & "c"`), `a &lt; b &amp; &#34;c&#34;`)
+	testValue(t, EscapeAttr("a\tb\r\nc<"), `a&#x9;b&#xD;&#xA;c&lt;`)
+	testValue(t, UnescapeText(`a &lt; b &amp; &#34;c&#x22; &apos;&foo; &`), `a < b & "c" '&foo; &`)
+	testValue(t, UnescapeAttr(EscapeAttr("x\r\ny & 'z'")), "x\r\ny & 'z'")
+
+	n := &Node{Type: ElementNode, Data: "a"}
+	n.SetAttr("v", "1\r\n2")
+	testValue(t, n.OutputXML(true), `<a v="1&#xD;&#xA;2"></a>`)
+	doc, err := Parse(strings.NewReader(n.OutputXML(true)))
+	testTrue(t, err == nil)
+	testValue(t, FindOne(doc, "//a").SelectAttr("v"), "1\r\n2")
+}
+
+func TestIsValidName(t *testing.T) {
+	for _, s := range []string{"a", "_a", "a-b.c", "x:y", ":a", "élan", "a·b", "日本"} {
+		testTrue(t, IsValidName(s))
+	}
+	for _, s := range []string{"", "1a", "-a", ".a", "a b", "a<b", "·a", "a\xff"} {
+		testTrue(t, !IsValidName(s))
+	}
+	for _, s := range []string{"a", "x:y", "ns1:book-list"} {
+		testTrue(t, IsValidQName(s))
+	}
+	for _, s := range []string{"", ":a", "a:", "a:b:c", "x:1y", "1x:y"} {
+		testTrue(t, !IsValidQName(s))
+	}
+}
+
+func TestStandaloneRoundTrip(t *testing.T) {
+	for _, s := range []string{
+		`<?xml version="1.0" standalone="yes"?><a></a>`,
+		`<?xml version="1.0" encoding="UTF-8" standalone="no"?><a></a>`,
+	} {
+		testValue(t, loadXML(s).OutputXML(true), s)
+	}
+}
+
+func TestSetDeclaration(t *testing.T) {
+	doc := &Node{Type: DocumentNode}
+	AddChild(doc, &Node{Type: ElementNode, Data: "a", level: 1})
+	doc.SetDeclaration("1.0", "UTF-8", "")
+	testValue(t, doc.OutputXML(true), `<?xml version="1.0" encoding="UTF-8"?><a></a>`)
+	testValue(t, doc.FirstChild.NextSibling.PrevSibling, doc.FirstChild)
+
+	// an existing declaration is updated in place
+	FindOne(doc, "//a").SetDeclaration("1.0", "", "yes")
+	testValue(t, doc.OutputXML(true), `<?xml version="1.0" standalone="yes"?><a></a>`)
+	testValue(t, doc.LastChild.Data, "a")
+	doc.SetDeclaration("1.0", "UTF-8", "no")
+	testValue(t, doc.OutputXML(true), `<?xml version="1.0" encoding="UTF-8" standalone="no"?><a></a>`)
+	standalone, ok := doc.Standalone()
+	testTrue(t, !standalone && ok)
+
+	empty := &Node{Type: DocumentNode}
+	empty.SetDeclaration("1.0", "", "")
+	testValue(t, empty.OutputXML(true), `<?xml version="1.0"?>`)
+	testValue(t, empty.LastChild, empty.FirstChild)
+
+	// a tree built from an element gets a document node
+	root := CreateElement("", "root", "")
+	testTrue(t, root.AppendXML("<child/>") == nil)
+	child := root.FirstChild
+	root.SetDeclaration("1.0", "UTF-8", "yes")
+	doc = root.OwnerDocument()
+	testValue(t, doc.Type, DocumentNode)
+	verifyNodePointers(t, doc)
+	testValue(t, doc.OutputXML(true), `<?xml version="1.0" encoding="UTF-8" standalone="yes"?><root><child></child></root>`)
+	testValue(t, root.Level(), doc.Level()+1)
+	testValue(t, child.Level(), doc.Level()+2)
+	ok, err := IsWellFormed(strings.NewReader(doc.OutputXML(true)))
+	testTrue(t, ok && err == nil)
+	testValue(t, root.OutputXML(true), `<root><child></child></root>`)
+}
+
+func TestOwnerDocument(t *testing.T) {
+	doc := loadXML(`<a><b><c/></b></a>`)
+	c := FindOne(doc, "//c")
+	testValue(t, c.OwnerDocument(), doc)
+	testValue(t, doc.OwnerDocument(), doc)
+	b := c.Parent
+	RemoveFromTree(b)
+	testValue(t, b.OwnerDocument(), b)
+	testValue(t, c.OwnerDocument(), c)
+}
+
+func TestRootNamespace(t *testing.T) {
+	doc := loadXML(`<?xml version="1.0"?><!-- feed --><feed xmlns="http://www.w3.org/2005/Atom"><entry><title>t</title></entry></feed>`)
+	title := FindOne(doc, "//*[local-name()='title']")
+	for _, n := range []*Node{doc, title} {
+		testValue(t, n.RootNamespace(), "http://www.w3.org/2005/Atom")
+		testValue(t, n.RootLocalName(), "feed")
+	}
+	soap := loadXML(`<soap:Envelope xmlns:soap="http://www.w3.org/2003/05/soap-envelope"/>`)
+	testValue(t, soap.RootLocalName(), "Envelope")
+	testValue(t, soap.RootNamespace(), "http://www.w3.org/2003/05/soap-envelope")
+
+	testValue(t, (&Node{Type: DocumentNode}).RootLocalName(), "")
+	testValue(t, (&Node{Type: ElementNode, Data: "rss"}).RootLocalName(), "rss")
+}
@@ -7,12 +7,103 @@ import (
 
 type ParserOptions struct {
 	Decoder *DecoderOptions
+	// StrictLeadingBytes disables the stripping of a UTF-8 byte order mark
+	// and any whitespace preceding the XML declaration or the root
+	// element, so they are read by the decoder as the rest of the input.
+	// The leading bytes are tolerated by default: the option is the
+	// inverse of a TolerateLeadingBytes option defaulting to true, which a
+	// bool field can't express, so the zero ParserOptions behave as Parse.
+	StrictLeadingBytes bool
+	// InternNames makes identical element and attribute local names share
+	// a single string, which reduces memory for large documents with
+	// many repeated names. The interning table is private to each parse.
+	InternNames bool
+	// AllowUndeclaredNamespacePrefix keeps the prefix of elements and
+	// attributes whose prefix is not bound to a namespace as part of their
+	// name, with an empty namespace URI, instead of failing the parse.
+	AllowUndeclaredNamespacePrefix bool
+	// ProgressFunc, if not nil, is called periodically during the parse
+	// with the number of bytes of input consumed so far, and once more at
+	// the end of the input.
+	ProgressFunc func(bytesRead int64)
+	// OnDuplicateAttr specifies how attributes repeated on an element are
+	// handled. By default all of them are kept.
+	OnDuplicateAttr DuplicateAttrMode
+	// MaxTokenLength, if greater than zero, is the maximum size in bytes
+	// of a token in the input: a start tag with its attributes, an end
+	// tag, a text or CDATA run, a comment, a processing instruction or a
+	// directive. The parse fails as soon as more bytes are read for a
+	// token, before the token is buffered in memory.
+	MaxTokenLength int
+	// DisableDTD drops <!DOCTYPE> declarations, including their internal
+	// subset, instead of keeping them as NotationNode nodes, so they are
+	// neither queried nor written back. The parser never resolves external
+	// entities or loads external DTDs, whether this is set or not.
+	DisableDTD bool
+	// Arena, if not nil, is where the nodes of the document are allocated,
+	// so parsing large documents makes far fewer allocations. See
+	// NodeArena for the memory it retains.
+	Arena *NodeArena
+	// KeepRawAttrValues keeps the source text of the attribute values,
+	// with their quoting style and entity or character references, so
+	// the output functions write them back verbatim until they're changed.
+	// Values are not kept for start tags larger than 4KB.
+	KeepRawAttrValues bool
+	// NormalizeAttrValues applies the attribute-value normalization of
+	// the XML specification for attributes of type CDATA: tabs, carriage
+	// returns and line feeds are replaced by spaces, while those written
+	// as character references, such as &#10;, are kept.
+	NormalizeAttrValues bool
+	// RecordSelfClosing records which elements are written as empty-element
+	// tags, such as <x/>, rather than with an end tag, as reported by
+	// Node.SelfClosed and reproduced by the WithSourceEmptyTags output
+	// option. It's not recorded for start tags larger than 4KB.
+	RecordSelfClosing bool
 }
 
+// A DuplicateAttrMode specifies how the parser handles an attribute that
+// appears more than once on the same element, such as <a x="1" x="2">.
+// Attributes are duplicates if they have the same local name and namespace.
+type DuplicateAttrMode int
+
+const (
+	// DuplicateAttrKeep keeps every occurrence of the attribute.
+	DuplicateAttrKeep DuplicateAttrMode = iota
+	// DuplicateAttrError fails the parse, as required by the XML
+	// specification.
+	DuplicateAttrError
+	// DuplicateAttrFirstWins keeps the first occurrence of the attribute
+	// and discards the others.
+	DuplicateAttrFirstWins
+	// DuplicateAttrLastWins keeps a single attribute, at the position of
+	// the first occurrence, with the value of the last one.
+	DuplicateAttrLastWins
+)
+
 func (options ParserOptions) apply(parser *parser) {
+	if options.MaxTokenLength > 0 {
+		parser.limitTokenLength(options.MaxTokenLength)
+	}
 	if options.Decoder != nil {
 		(*options.Decoder).apply(parser.decoder)
 	}
+	if parser.limiter != nil {
+		parser.limitDecodedInput()
+	}
+	if !options.StrictLeadingBytes {
+		parser.skipLeadingBytes()
+	}
+	if options.InternNames {
+		parser.names = make(map[string]string)
+	}
+	parser.allowUndeclaredPrefix = options.AllowUndeclaredNamespacePrefix
+	parser.progress = options.ProgressFunc
+	parser.duplicateAttr = options.OnDuplicateAttr
+	parser.disableDTD = options.DisableDTD
+	parser.arena = options.Arena
+	parser.keepRawAttrValues = options.KeepRawAttrValues
+	parser.normalizeAttrValues = options.NormalizeAttrValues
+	parser.recordSelfClosing = options.RecordSelfClosing
 }
 
 // DecoderOptions implement the very same options than the standard
@@ -3,6 +3,9 @@ package xmlquery
 import (
 	"bytes"
 	"encoding/xml"
+	"io"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -44,3 +47,179 @@ func TestApplyEmptyOptions(t *testing.T) {
 	// expecting this call to do anything
 	options.apply(parser)
 }
+
+func TestInternNamesOption(t *testing.T) {
+	s := `<list><item id="1"/><item id="2"/><item id="3"><name>x</name></item></list>`
+	p := createParser(strings.NewReader(s))
+	ParserOptions{InternNames: true}.apply(p)
+	var err error
+	for err == nil {
+		_, err = p.parse()
+	}
+	if err != io.EOF {
+		t.Fatal(err)
+	}
+	if len(p.names) != 4 {
+		t.Fatalf("Expected 4 interned names, got %v instead", p.names)
+	}
+	if output := p.doc.OutputXML(false); output != `<?xml version="1.0"?>`+`<list><item id="1"></item><item id="2"></item><item id="3"><name>x</name></item></list>` {
+		t.Fatalf("Unexpected output %s", output)
+	}
+
+	p = createParser(strings.NewReader(s))
+	ParserOptions{}.apply(p)
+	if p.names != nil {
+		t.Fatal("Expected no interning table by default")
+	}
+}
+
+func TestProgressFuncOption(t *testing.T) {
+	var b strings.Builder
+	b.WriteString("<list>")
+	for i := 0; i < 20000; i++ {
+		b.WriteString("<item>value</item>")
+	}
+	b.WriteString("</list>")
+	s := b.String()
+
+	var calls []int64
+	_, err := ParseWithOptions(strings.NewReader(s), ParserOptions{
+		ProgressFunc: func(bytesRead int64) {
+			calls = append(calls, bytesRead)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) < 2 {
+		t.Fatalf("Expected several progress calls, got %v", calls)
+	}
+	for i := 1; i < len(calls); i++ {
+		if calls[i] <= calls[i-1] {
+			t.Fatalf("Expected increasing progress, got %v", calls)
+		}
+	}
+	if last := calls[len(calls)-1]; last != int64(len(s)) {
+		t.Fatalf("Expected last progress of %d, got %d instead", len(s), last)
+	}
+}
+
+func TestOnDuplicateAttrOption(t *testing.T) {
+	s := `<a x="1" xmlns:p="urn:p" p:y="2" x="3" p:y="4" z="5"/>`
+	for _, test := range []struct {
+		mode     DuplicateAttrMode
+		expected string
+	}{
+		{DuplicateAttrKeep, `<a x="1" xmlns:p="urn:p" p:y="2" x="3" p:y="4" z="5"></a>`},
+		{DuplicateAttrFirstWins, `<a x="1" xmlns:p="urn:p" p:y="2" z="5"></a>`},
+		{DuplicateAttrLastWins, `<a x="3" xmlns:p="urn:p" p:y="4" z="5"></a>`},
+	} {
+		doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{OnDuplicateAttr: test.mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if output := FindOne(doc, "/a").OutputXML(true); output != test.expected {
+			t.Fatalf("Expected %s for mode %d, got %s instead", test.expected, test.mode, output)
+		}
+	}
+
+	_, err := ParseWithOptions(strings.NewReader(s), ParserOptions{OnDuplicateAttr: DuplicateAttrError})
+	if err == nil || !strings.Contains(err.Error(), "duplicate attribute x") {
+		t.Fatalf("Expected a duplicate attribute error, got %v", err)
+	}
+	// the same local name in different namespaces is not a duplicate
+	_, err = ParseWithOptions(strings.NewReader(`<a xmlns:p="urn:p" p:x="1" x="2"/>`), ParserOptions{OnDuplicateAttr: DuplicateAttrError})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxTokenLengthOption(t *testing.T) {
+	long := strings.Repeat("x", 65)
+	for _, s := range []string{
+		`<` + long + `/>`,
+		`<a ` + long + `="1"/>`,
+		`<a b="` + long + `"/>`,
+		`<a>` + long + `</a>`,
+		`<a><![CDATA[` + long + `]]></a>`,
+		`<a><!--` + long + `--></a>`,
+	} {
+		_, err := ParseWithOptions(strings.NewReader(s), ParserOptions{MaxTokenLength: 64})
+		if err == nil || !strings.Contains(err.Error(), "exceeds the maximum length of 64") {
+			t.Fatalf("Expected a token length error for %s, got %v", s, err)
+		}
+	}
+
+	s := `<a b="` + long[:56] + `">` + long[1:] + `</a>`
+	if _, err := ParseWithOptions(strings.NewReader(s), ParserOptions{MaxTokenLength: 64}); err != nil {
+		t.Fatal(err)
+	}
+	// the limit applies to the decoded input of other encodings
+	s = `<?xml version="1.0" encoding="ISO-8859-1"?><a>` + long[:60] + "\xe9</a>"
+	if _, err := ParseWithOptions(strings.NewReader(s), ParserOptions{MaxTokenLength: 64}); err != nil {
+		t.Fatal(err)
+	}
+	s = `<?xml version="1.0" encoding="ISO-8859-1"?><a>` + long + `</a>`
+	if _, err := ParseWithOptions(strings.NewReader(s), ParserOptions{MaxTokenLength: 64}); err == nil {
+		t.Fatal("Expected a token length error for ISO-8859-1 input")
+	}
+	if _, err := ParseWithOptions(strings.NewReader(`<a>`+long+`</a>`), ParserOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type repeatReader byte
+
+func (r repeatReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = byte(r)
+	}
+	return len(b), nil
+}
+
+func TestMaxTokenLengthLargeToken(t *testing.T) {
+	const size = 8 << 20
+	r := io.MultiReader(strings.NewReader("<a>"), io.LimitReader(repeatReader('x'), size), strings.NewReader("</a>"))
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	_, err := ParseWithOptions(r, ParserOptions{MaxTokenLength: 1024})
+	runtime.ReadMemStats(&after)
+	if err == nil || !strings.Contains(err.Error(), "exceeds the maximum length of 1024") {
+		t.Fatalf("Expected a token length error, got %v", err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/8 {
+		t.Fatalf("Expected the token not to be buffered, %d bytes allocated", allocated)
+	}
+}
+
+func TestDisableDTDOption(t *testing.T) {
+	s := `<?xml version="1.0"?>
+<!DOCTYPE foo [ <!ENTITY xxe SYSTEM "file:///etc/passwd"> ]>
+<foo>bar</foo>`
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{DisableDTD: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == NotationNode {
+			t.Fatalf("Expected no DOCTYPE node, got %s", n.Data)
+		}
+	}
+	if output := doc.OutputXML(false); strings.Contains(output, "DOCTYPE") {
+		t.Fatalf("Unexpected DOCTYPE in output %s", output)
+	}
+
+	// referencing the external entity is an error rather than a file access
+	_, err = ParseWithOptions(strings.NewReader(strings.Replace(s, "bar", "&xxe;", 1)), ParserOptions{DisableDTD: true})
+	if err == nil {
+		t.Fatal("Expected an error for the undefined entity")
+	}
+
+	doc, err = ParseWithOptions(strings.NewReader(s), ParserOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := FindOne(doc, "//foo"); n.PrevSibling.Type != TextNode || n.PrevSibling.PrevSibling.Type != NotationNode {
+		t.Fatal("Expected the DOCTYPE node to be kept by default")
+	}
+}
@@ -3,13 +3,19 @@ package xmlquery
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"os"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"unicode/utf8"
 
 	"github.com/antchfx/xpath"
 	"golang.org/x/net/html/charset"
@@ -17,7 +23,10 @@ import (
 
 var xmlMIMERegex = regexp.MustCompile(`(?i)((application|image|message|model)/((\w|\.|-)+\+?)?|text/)(wb)?xml`)
 
-// LoadURL loads the XML document from the specified URL.
+// LoadURL loads the XML document from the specified URL. A response body
+// with a deflate or x-gzip Content-Encoding is decompressed. One compressed
+// with gzip is usually decompressed by net/http already, which asks for it,
+// and is only decompressed here if http.DefaultTransport disables that.
 func LoadURL(url string) (*Node, error) {
 	resp, err := http.Get(url)
 	if err != nil {
@@ -26,7 +35,24 @@ func LoadURL(url string) (*Node, error) {
 	defer resp.Body.Close()
 	// Make sure the Content-Type has a valid XML MIME type
 	if xmlMIMERegex.MatchString(resp.Header.Get("Content-Type")) {
-		return Parse(resp.Body)
+		var body io.Reader = resp.Body
+		switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+		case "gzip", "x-gzip":
+			gr, err := gzip.NewReader(resp.Body)
+			if err != nil {
+				return nil, err
+			}
+			defer gr.Close()
+			body = gr
+		case "deflate":
+			zr, err := zlib.NewReader(resp.Body)
+			if err != nil {
+				return nil, err
+			}
+			defer zr.Close()
+			body = zr
+		}
+		return Parse(body)
 	}
 	return nil, fmt.Errorf("invalid XML document(%s)", resp.Header.Get("Content-Type"))
 }
@@ -36,10 +62,72 @@ func Parse(r io.Reader) (*Node, error) {
 	return ParseWithOptions(r, ParserOptions{})
 }
 
+// ParseCompressed is like Parse, but decompresses the input first if it's
+// gzip or zlib compressed, as detected from its leading magic bytes.
+func ParseCompressed(r io.Reader) (*Node, error) {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(2)
+	switch {
+	case len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b:
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return Parse(zr)
+	case len(magic) == 2 && magic[0]&0x0f == 8 && (uint(magic[0])<<8|uint(magic[1]))%31 == 0:
+		zr, err := zlib.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return Parse(zr)
+	}
+	return Parse(br)
+}
+
+// ParseBytes returns the parse tree for the XML in data.
+func ParseBytes(data []byte) (*Node, error) {
+	return Parse(bytes.NewReader(data))
+}
+
 // ParseWithOptions is like parse, but with custom options
 func ParseWithOptions(r io.Reader, options ParserOptions) (*Node, error) {
 	p := createParser(r)
 	options.apply(p)
+	return p.parseDocument()
+}
+
+// ParseWithWarnings is like ParseWithOptions, but also returns the issues
+// of the input that the parser recovered from, in input order. There can
+// only be such issues if options.Decoder disables Strict mode: elements
+// closed automatically, because of Decoder.AutoClose or of a mismatched end
+// tag, unknown entities and unescaped & characters kept as text, and
+// undeclared namespace prefixes.
+func ParseWithWarnings(r io.Reader, options ParserOptions) (*Node, []ParseWarning, error) {
+	p := createParser(r)
+	options.apply(p)
+	warnings := []ParseWarning{}
+	p.warnings = &warnings
+	doc, err := p.parseDocument()
+	if len(warnings) == 0 {
+		warnings = nil
+	}
+	return doc, warnings, err
+}
+
+// A ParseWarning describes an issue of the input that the parser recovered
+// from.
+type ParseWarning struct {
+	Offset  int64 // The input offset following the token with the issue.
+	Message string
+}
+
+func (w ParseWarning) String() string {
+	return fmt.Sprintf("offset %d: %s", w.Offset, w.Message)
+}
+
+func (p *parser) parseDocument() (*Node, error) {
 	var err error
 	for err == nil {
 		_, err = p.parse()
@@ -67,18 +155,243 @@ func ParseWithOptions(r io.Reader, options ParserOptions) (*Node, error) {
 	return nil, err
 }
 
+// ParseAll parses the XML documents written one after another in r, such
+// as in a log file, and returns one tree for each, in order. A document
+// ends when its root element is closed and a new one starts at the next
+// XML declaration or element; the comments, processing instructions and
+// whitespace in between belong to the preceding document.
+func ParseAll(r io.Reader) ([]*Node, error) {
+	p := createParser(r)
+	ParserOptions{}.apply(p)
+	p.multiDocument = true
+	var err error
+	for err == nil {
+		_, err = p.parse()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	if p.doc.FirstChild != nil {
+		if !p.rootClosed {
+			return nil, fmt.Errorf("xmlquery: invalid XML document")
+		}
+		p.docs = append(p.docs, p.doc)
+	}
+	return p.docs, nil
+}
+
+// IsWellFormed reads r to its end and reports whether it's a well-formed
+// XML document: a single root element, properly nested and closed, with
+// declared namespace prefixes and nothing but whitespace, comments and
+// processing instructions around it. No tree is built. If the document is
+// not well-formed, the returned error describes the first issue found, or
+// is the error returned by r.
+func IsWellFormed(r io.Reader) (bool, error) {
+	buf := bufio.NewReader(r)
+	if b, err := buf.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		buf.Discard(len(utf8BOM))
+	}
+	decoder := xml.NewDecoder(buf)
+	decoder.CharsetReader = charset.NewReaderLabel
+	// The raw tokens are read, as undeclared prefixes can't be told from
+	// namespace URIs once translated, so the nesting is checked here. ns
+	// holds the URIs bound to each prefix by the open elements, innermost
+	// last.
+	ns := map[string][]string{"xml": {xmlNamespaceURI}}
+	resolve := func(prefix string) (string, bool) {
+		if prefix == "" {
+			return "", true
+		}
+		uris := ns[prefix]
+		if len(uris) == 0 {
+			return "", false
+		}
+		return uris[len(uris)-1], true
+	}
+	rawName := func(name xml.Name) string {
+		if name.Space != "" {
+			return name.Space + ":" + name.Local
+		}
+		return name.Local
+	}
+	type openElement struct {
+		name     xml.Name
+		prefixes []string
+	}
+	var stack []openElement
+	roots := 0
+	for {
+		tok, err := decoder.RawToken()
+		if err == io.EOF {
+			if len(stack) > 0 {
+				return false, fmt.Errorf("xmlquery: invalid XML document, element %s is not closed", stack[len(stack)-1].name.Local)
+			}
+			if roots == 0 {
+				return false, fmt.Errorf("xmlquery: invalid XML document, no root element")
+			}
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if len(stack) == 0 {
+				if roots++; roots > 1 {
+					return false, fmt.Errorf("xmlquery: invalid XML document, several root elements")
+				}
+			}
+			var prefixes []string
+			for _, attr := range tok.Attr {
+				if attr.Name.Space == "xmlns" {
+					ns[attr.Name.Local] = append(ns[attr.Name.Local], attr.Value)
+					prefixes = append(prefixes, attr.Name.Local)
+				}
+			}
+			stack = append(stack, openElement{name: tok.Name, prefixes: prefixes})
+			if _, ok := resolve(tok.Name.Space); !ok {
+				return false, fmt.Errorf("xmlquery: invalid XML document, namespace prefix %s is not declared", tok.Name.Space)
+			}
+			// Attributes are duplicates if they have the same expanded
+			// name, whatever their prefixes.
+			names := make([]xml.Name, len(tok.Attr))
+			for i, attr := range tok.Attr {
+				names[i] = attr.Name
+				if _, ok := namespaceDeclPrefix(Attr{Name: attr.Name}); !ok && attr.Name.Space != "" {
+					uri, ok := resolve(attr.Name.Space)
+					if !ok {
+						return false, fmt.Errorf("xmlquery: invalid XML document, namespace prefix %s is not declared", attr.Name.Space)
+					}
+					names[i] = xml.Name{Space: uri, Local: attr.Name.Local}
+				}
+				for _, other := range names[:i] {
+					if other == names[i] {
+						return false, fmt.Errorf("xmlquery: invalid XML document, duplicate attribute %s on element %s", attr.Name.Local, tok.Name.Local)
+					}
+				}
+			}
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return false, fmt.Errorf("xmlquery: invalid XML document, unexpected end element </%s>", tok.Name.Local)
+			}
+			open := stack[len(stack)-1]
+			if open.name != tok.Name {
+				return false, fmt.Errorf("xmlquery: invalid XML document, element <%s> closed by </%s>", rawName(open.name), rawName(tok.Name))
+			}
+			for _, prefix := range open.prefixes {
+				ns[prefix] = ns[prefix][:len(ns[prefix])-1]
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) == 0 && len(bytes.TrimSpace(tok)) > 0 {
+				return false, fmt.Errorf("xmlquery: invalid XML document, text outside of the root element")
+			}
+		}
+	}
+}
+
+// ParseFragment parses an XML fragment which, unlike a document, may have
+// several top-level nodes, and returns these nodes. The returned nodes are
+// detached from each other and have no parent.
+func ParseFragment(r io.Reader) ([]*Node, error) {
+	return parseFragment(r, "")
+}
+
+// parseFragment is like ParseFragment, with namespace declarations, such
+// as ` xmlns:x="urn:x"`, in scope of the fragment.
+func parseFragment(r io.Reader, namespaces string) ([]*Node, error) {
+	const wrapper = "xmlquery-fragment"
+	r = io.MultiReader(strings.NewReader("<"+wrapper+namespaces+">"), r, strings.NewReader("</"+wrapper+">"))
+	doc, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+	var root *Node
+	for n := doc.FirstChild; n != nil; n = n.NextSibling {
+		if n.Type == ElementNode {
+			root = n
+			break
+		}
+	}
+	var nodes []*Node
+	for n := root.FirstChild; n != nil; {
+		next := n.NextSibling
+		n.Parent, n.PrevSibling, n.NextSibling = nil, nil, nil
+		shiftLevel(n, -1)
+		nodes = append(nodes, n)
+		n = next
+	}
+	return nodes, nil
+}
+
 type parser struct {
-	decoder             *xml.Decoder
-	doc                 *Node
-	level               int
-	prev                *Node
-	streamElementXPath  *xpath.Expr   // Under streaming mode, this specifies the xpath to the target element node(s).
-	streamElementFilter *xpath.Expr   // If specified, it provides further filtering on the target element.
-	streamNode          *Node         // Need to remember the last target node So we can clean it up upon next Read() call.
-	streamNodePrev      *Node         // Need to remember target node's prev so upon target node removal, we can restore correct prev.
-	reader              *cachedReader // Need to maintain a reference to the reader, so we can determine whether a node contains CDATA.
-	once                sync.Once
-	space2prefix        map[string]*xmlnsPrefix
+	decoder               *xml.Decoder
+	doc                   *Node
+	level                 int
+	prev                  *Node
+	streamElementXPath    *xpath.Expr   // Under streaming mode, this specifies the xpath to the target element node(s).
+	streamElementFilter   *xpath.Expr   // If specified, it provides further filtering on the target element.
+	streamNode            *Node         // Need to remember the last target node So we can clean it up upon next Read() call.
+	streamNodePrev        *Node         // Need to remember target node's prev so upon target node removal, we can restore correct prev.
+	reader                *cachedReader // Need to maintain a reference to the reader, so we can determine whether a node contains CDATA.
+	once                  sync.Once
+	space2prefix          map[string]*xmlnsPrefix
+	names                 map[string]string // If not nil, the table used to intern names.
+	allowUndeclaredPrefix bool
+	progress              func(int64)
+	progressOffset        int64 // The input offset last reported to progress.
+	duplicateAttr         DuplicateAttrMode
+	maxTokenLength        int
+	limiter               *tokenLimitReader // The input of the decoder, if maxTokenLength is set.
+	disableDTD            bool
+	multiDocument         bool            // Whether the input may contain several documents.
+	rootClosed            bool            // Whether the root element of the current document is closed.
+	docs                  []*Node         // The previous documents of the input, in multi-document mode.
+	warnings              *[]ParseWarning // If not nil, where the recovered issues are collected.
+	lastRaw               []byte          // The input of the previous token, when collecting warnings.
+	arena                 *NodeArena      // If not nil, where the nodes are allocated.
+	keepRawAttrValues     bool
+	normalizeAttrValues   bool
+	recordSelfClosing     bool
+}
+
+// dedupAttrs handles the attributes of tok that have the same expanded
+// name as an earlier one, according to p.duplicateAttr. attrs are the
+// attributes built from tok.Attr, in the same order.
+func (p *parser) dedupAttrs(tok xml.StartElement, attrs []Attr) ([]Attr, error) {
+	var list []Attr
+	seen := make(map[xml.Name]int, len(attrs))
+	for i, attr := range attrs {
+		j, found := seen[tok.Attr[i].Name]
+		if !found {
+			seen[tok.Attr[i].Name] = len(list)
+			list = append(list, attr)
+			continue
+		}
+		switch p.duplicateAttr {
+		case DuplicateAttrError:
+			return nil, fmt.Errorf("xmlquery: duplicate attribute %s on element %s", attr.Name.Local, tok.Name.Local)
+		case DuplicateAttrLastWins:
+			list[j].Value = attr.Value
+		}
+	}
+	return list, nil
+}
+
+// nextDocument saves the current document and starts a new one, in
+// multi-document mode.
+func (p *parser) nextDocument() {
+	p.docs = append(p.docs, p.doc)
+	p.doc = &Node{Type: DocumentNode}
+	p.prev = p.doc
+	p.level = 0
+	p.rootClosed = false
+	p.space2prefix = map[string]*xmlnsPrefix{"http://www.w3.org/XML/1998/namespace": {name: "xml", level: 0}}
+}
+
+func isStartElement(tok xml.Token) bool {
+	_, ok := tok.(xml.StartElement)
+	return ok
 }
 
 type xmlnsPrefix struct {
@@ -101,6 +414,341 @@ func createParser(r io.Reader) *parser {
 	return p
 }
 
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// skipLeadingBytes discards a UTF-8 byte order mark and whitespace at the
+// beginning of the input, so they don't end up as stray text nodes.
+func (p *parser) skipLeadingBytes() {
+	if p.reader == nil {
+		return
+	}
+	r := p.reader.buffer
+	if b, err := r.Peek(len(utf8BOM)); err == nil && bytes.Equal(b, utf8BOM) {
+		r.Discard(len(utf8BOM))
+	}
+	for {
+		b, err := r.Peek(1)
+		if err != nil {
+			return
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			r.Discard(1)
+		default:
+			return
+		}
+	}
+}
+
+// progressInterval is the number of input bytes between two calls of the
+// progress function.
+const progressInterval = 64 << 10
+
+func (p *parser) reportProgress(done bool) {
+	offset := p.decoder.InputOffset()
+	if offset-p.progressOffset >= progressInterval || done && offset != p.progressOffset {
+		p.progressOffset = offset
+		p.progress(offset)
+	}
+}
+
+var errTokenTooLong = errors.New("xmlquery: token too long")
+
+// tokenLimitReader is the input of the decoder when the length of tokens
+// is limited. It fails once more than max bytes are read for a token, so
+// the decoder never buffers more than that.
+type tokenLimitReader struct {
+	r        io.ByteReader
+	n        int // bytes read since the start of the current token
+	max      int
+	exceeded bool
+}
+
+func (l *tokenLimitReader) ReadByte() (byte, error) {
+	// The decoder may read the first byte of the next token, so one byte
+	// more than max is allowed.
+	if l.n > l.max {
+		l.exceeded = true
+		return 0, errTokenTooLong
+	}
+	b, err := l.r.ReadByte()
+	if err == nil {
+		l.n++
+	}
+	return b, err
+}
+
+func (l *tokenLimitReader) Read(b []byte) (int, error) {
+	for i := range b {
+		c, err := l.ReadByte()
+		if err != nil {
+			return i, err
+		}
+		b[i] = c
+	}
+	return len(b), nil
+}
+
+// limitTokenLength makes the decoder fail on tokens longer than max bytes
+// in the input. It must be called before the decoder options are applied.
+func (p *parser) limitTokenLength(max int) {
+	p.maxTokenLength = max
+	p.limiter = &tokenLimitReader{r: p.reader, max: max}
+	p.decoder = xml.NewDecoder(p.limiter)
+	p.decoder.CharsetReader = charset.NewReaderLabel
+}
+
+// limitDecodedInput makes the limit of the token length apply to the
+// decoded input when the document declares an encoding other than UTF-8,
+// as the charset reader reads its input by chunks.
+func (p *parser) limitDecodedInput() {
+	newReader := p.decoder.CharsetReader
+	if newReader == nil {
+		return
+	}
+	p.decoder.CharsetReader = func(label string, _ io.Reader) (io.Reader, error) {
+		r, err := newReader(label, p.limiter.r.(io.Reader))
+		if err != nil {
+			return nil, err
+		}
+		p.limiter.r = bufio.NewReader(r)
+		return p.limiter, nil
+	}
+}
+
+func (p *parser) warn(format string, args ...interface{}) {
+	*p.warnings = append(*p.warnings, ParseWarning{
+		Offset:  p.decoder.InputOffset(),
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// parsePseudoAttrs splits the content of a processing instruction into
+// name and value pairs, such as `type="text/xsl" href='s.xsl'`. ok is false
+// if inst doesn't follow the pseudo-attribute syntax. The values are not
+// unescaped.
+func parsePseudoAttrs(inst string) (pairs [][2]string, ok bool) {
+	isSpace := func(b byte) bool { return b == ' ' || b == '\t' || b == '\r' || b == '\n' }
+	i := 0
+	for {
+		for i < len(inst) && isSpace(inst[i]) {
+			i++
+		}
+		if i == len(inst) {
+			return pairs, true
+		}
+		start := i
+		for i < len(inst) && inst[i] != '=' && !isSpace(inst[i]) {
+			i++
+		}
+		name := inst[start:i]
+		for i < len(inst) && isSpace(inst[i]) {
+			i++
+		}
+		if name == "" || i == len(inst) || inst[i] != '=' {
+			return nil, false
+		}
+		i++
+		for i < len(inst) && isSpace(inst[i]) {
+			i++
+		}
+		if i == len(inst) || inst[i] != '"' && inst[i] != '\'' {
+			return nil, false
+		}
+		end := strings.IndexByte(inst[i+1:], inst[i])
+		if end < 0 {
+			return nil, false
+		}
+		pairs = append(pairs, [2]string{name, inst[i+1 : i+1+end]})
+		i += end + 2
+	}
+}
+
+// normalizeAttrValue returns the attribute value normalized as required
+// for attributes of type CDATA: each whitespace character is replaced by a
+// space, except those written as character references. raw is the quoted
+// source text of the value, if known, used to find these references.
+func normalizeAttrValue(value, raw string) string {
+	toSpace := func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' {
+			return ' '
+		}
+		return r
+	}
+	if !strings.Contains(raw, "&#") {
+		return strings.Map(toSpace, value)
+	}
+	// Normalize the source text, keeping the character references, and
+	// decode it again. The line ends are already normalized in value, so
+	// \r\n is a single space.
+	raw = strings.Replace(raw[1:len(raw)-1], "\r\n", "\n", -1)
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		switch c := raw[i]; {
+		case c == '\t' || c == '\n' || c == '\r':
+			b.WriteByte(' ')
+		case c == '&':
+			end := strings.IndexByte(raw[i:], ';')
+			if end < 0 {
+				return strings.Map(toSpace, value)
+			}
+			ref := raw[i+1 : i+end]
+			if r, ok := decodeCharRef(ref); ok {
+				b.WriteRune(r)
+			} else if s, ok := predefinedEntities[ref]; ok {
+				b.WriteString(s)
+			} else {
+				// Entities declared in the decoder can't be told apart.
+				return strings.Map(toSpace, value)
+			}
+			i += end
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// decodeCharRef decodes a character reference without its & and ;, such
+// as #65 or #x41.
+func decodeCharRef(ref string) (rune, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return 0, false
+	}
+	base, digits := 10, ref[1:]
+	if strings.HasPrefix(digits, "x") {
+		base, digits = 16, digits[1:]
+	}
+	n, err := strconv.ParseUint(digits, base, 32)
+	if err != nil || !utf8.ValidRune(rune(n)) {
+		return 0, false
+	}
+	return rune(n), true
+}
+
+// rawAttrValues returns the quoted attribute values of the start tag in
+// raw, in order. The leading '<' may be missing, as the decoder reads it
+// with the previous token.
+func rawAttrValues(raw []byte) []string {
+	isSpace := func(b byte) bool { return b == ' ' || b == '\t' || b == '\r' || b == '\n' }
+	i := 0
+	if i < len(raw) && raw[i] == '<' {
+		i++
+	}
+	for i < len(raw) && !isSpace(raw[i]) && raw[i] != '/' && raw[i] != '>' {
+		i++
+	}
+	var values []string
+	for {
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		if i == len(raw) || raw[i] == '/' || raw[i] == '>' {
+			return values
+		}
+		for i < len(raw) && raw[i] != '=' {
+			i++
+		}
+		i++
+		for i < len(raw) && isSpace(raw[i]) {
+			i++
+		}
+		if i >= len(raw) || raw[i] != '"' && raw[i] != '\'' {
+			return values
+		}
+		end := bytes.IndexByte(raw[i+1:], raw[i])
+		if end < 0 {
+			return values
+		}
+		values = append(values, string(raw[i:i+end+2]))
+		i += end + 2
+	}
+}
+
+// predefinedEntities are the entities that need no declaration, with
+// their replacement text.
+var predefinedEntities = map[string]string{"amp": "&", "lt": "<", "gt": ">", "apos": "'", "quot": `"`}
+
+// checkRecovered records a warning if tok was recovered from an issue of
+// the input, as found from the input the decoder read for it.
+func (p *parser) checkRecovered(tok xml.Token) {
+	raw := p.reader.Cache()
+	if len(raw) == 0 {
+		// The decoder returns a token it had already read after a
+		// synthesized one.
+		raw = p.lastRaw
+	} else {
+		p.lastRaw = append(p.lastRaw[:0], raw...)
+	}
+	switch tok := tok.(type) {
+	case xml.EndElement:
+		if bytes.HasSuffix(bytes.TrimRight(raw, " \t\r\n"), []byte("/>")) {
+			// the end of an empty element tag
+			return
+		}
+		if name := endTagName(raw); name != tok.Name.Local && !strings.HasSuffix(name, ":"+tok.Name.Local) {
+			p.warn("element <%s> closed automatically", tok.Name.Local)
+		}
+	case xml.StartElement, xml.CharData:
+		if bytes.HasPrefix(raw, []byte("<![CDATA[")) || bytes.HasPrefix(raw, []byte("![CDATA[")) {
+			return
+		}
+		for i := bytes.IndexByte(raw, '&'); i >= 0; i = bytes.IndexByte(raw, '&') {
+			raw = raw[i+1:]
+			end := bytes.IndexByte(raw, ';')
+			if end < 0 || end > 32 || !IsValidName(string(raw[:end])) && (end == 0 || raw[0] != '#') {
+				p.warn("unescaped & kept as text")
+				continue
+			}
+			name := string(raw[:end])
+			if _, ok := p.decoder.Entity[name]; !ok && predefinedEntities[name] == "" && name[0] != '#' {
+				p.warn("unknown entity &%s; kept as text", name)
+			}
+		}
+	}
+}
+
+// endTagName returns the name of the end tag at the beginning of raw, or an
+// empty string if there is none.
+func endTagName(raw []byte) string {
+	raw = bytes.TrimLeft(raw, " \t\r\n")
+	if bytes.HasPrefix(raw, []byte("</")) {
+		raw = raw[2:]
+	} else if bytes.HasPrefix(raw, []byte("/")) {
+		raw = raw[1:]
+	} else {
+		return ""
+	}
+	end := bytes.IndexAny(raw, " \t\r\n>")
+	if end < 0 {
+		return string(raw)
+	}
+	return string(raw[:end])
+}
+
+// intern returns the shared copy of the name s if interning is enabled.
+func (p *parser) intern(s string) string {
+	if p.names == nil {
+		return s
+	}
+	if v, ok := p.names[s]; ok {
+		return v
+	}
+	p.names[s] = s
+	return s
+}
+
+// newNode returns a new node initialized to n, allocated from p.arena if
+// there is one.
+func (p *parser) newNode(n Node) *Node {
+	if p.arena == nil {
+		return &n
+	}
+	node := p.arena.alloc()
+	*node = n
+	return node
+}
+
 func (p *parser) parse() (*Node, error) {
 	p.once.Do(func() {
 		p.space2prefix = map[string]*xmlnsPrefix{"http://www.w3.org/XML/1998/namespace": {name: "xml", level: 0}}
@@ -108,12 +756,32 @@ func (p *parser) parse() (*Node, error) {
 
 	var streamElementNodeCounter int
 	for {
+		offset := p.decoder.InputOffset()
+		if p.limiter != nil {
+			p.limiter.n = 0
+		}
 		p.reader.StartCaching()
 		tok, err := p.decoder.Token()
 		p.reader.StopCaching()
+		if p.progress != nil {
+			p.reportProgress(err == io.EOF)
+		}
+		// The decoder may return the token read so far or report another
+		// error, such as invalid UTF-8 for a character cut by the limit.
+		if p.limiter != nil && p.limiter.exceeded {
+			return nil, fmt.Errorf("xmlquery: token at offset %d exceeds the maximum length of %d", offset, p.maxTokenLength)
+		}
 		if err != nil {
 			return nil, err
 		}
+		if p.warnings != nil {
+			p.checkRecovered(tok)
+		}
+		if p.multiDocument && p.rootClosed {
+			if decl, ok := tok.(xml.ProcInst); ok && decl.Target == "xml" || isStartElement(tok) {
+				p.nextDocument()
+			}
+		}
 
 		switch tok := tok.(type) {
 		case xml.StartElement:
@@ -122,12 +790,12 @@ func (p *parser) parse() (*Node, error) {
 				attributes := make([]Attr, 1)
 				attributes[0].Name = xml.Name{Local: "version"}
 				attributes[0].Value = "1.0"
-				node := &Node{
+				node := p.newNode(Node{
 					Type:  DeclarationNode,
 					Data:  "xml",
 					Attr:  attributes,
 					level: 1,
-				}
+				})
 				AddChild(p.prev, node)
 				p.level = 1
 				p.prev = node
@@ -145,16 +813,27 @@ func (p *parser) parse() (*Node, error) {
 				}
 			}
 
+			// The decoder leaves the prefix in place of the namespace URL
+			// if the prefix is not declared.
+			var undeclared string
 			if space := tok.Name.Space; space != "" {
-				if _, found := p.space2prefix[space]; !found && p.decoder.Strict {
-					return nil, fmt.Errorf("xmlquery: invalid XML document, namespace %s is missing", space)
+				if _, found := p.space2prefix[space]; !found {
+					if p.allowUndeclaredPrefix {
+						undeclared = space
+					} else if p.decoder.Strict {
+						return nil, fmt.Errorf("xmlquery: invalid XML document, namespace %s is missing", space)
+					} else if p.warnings != nil {
+						p.warn("namespace prefix %s of element <%s> is not declared", space, tok.Name.Local)
+					}
 				}
 			}
 
 			attributes := make([]Attr, len(tok.Attr))
 			for i, att := range tok.Attr {
 				name := att.Name
-				if prefix, ok := p.space2prefix[name.Space]; ok {
+				name.Local = p.intern(name.Local)
+				// Unprefixed attributes are in no namespace.
+				if prefix, ok := p.space2prefix[name.Space]; ok && name.Space != "" {
 					name.Space = prefix.name
 				}
 				attributes[i] = Attr{
@@ -162,14 +841,54 @@ func (p *parser) parse() (*Node, error) {
 					Value:        att.Value,
 					NamespaceURI: att.Name.Space,
 				}
+				if _, found := p.space2prefix[att.Name.Space]; !found && p.allowUndeclaredPrefix && att.Name.Space != "xmlns" {
+					attributes[i].NamespaceURI = ""
+				}
 			}
 
-			node := &Node{
+			var rawAttrs []rawAttr
+			if p.keepRawAttrValues || p.normalizeAttrValues {
+				// The values are matched by position, so the source text
+				// is dropped if it can't be split into the same attributes.
+				raws := rawAttrValues(p.reader.Cache())
+				if len(raws) != len(attributes) {
+					raws = nil
+				}
+				for i := range attributes {
+					var raw string
+					if raws != nil {
+						raw = raws[i]
+					}
+					if p.normalizeAttrValues {
+						attributes[i].Value = normalizeAttrValue(attributes[i].Value, raw)
+					}
+					if p.keepRawAttrValues && raw != "" {
+						rawAttrs = append(rawAttrs, rawAttr{name: attributes[i].Name, raw: raw, value: attributes[i].Value})
+					}
+				}
+			}
+
+			if p.duplicateAttr != DuplicateAttrKeep {
+				var err error
+				if attributes, err = p.dedupAttrs(tok, attributes); err != nil {
+					return nil, err
+				}
+			}
+
+			node := p.newNode(Node{
 				Type:         ElementNode,
-				Data:         tok.Name.Local,
+				Data:         p.intern(tok.Name.Local),
 				NamespaceURI: tok.Name.Space,
 				Attr:         attributes,
 				level:        p.level,
+				rawAttrs:     rawAttrs,
+			})
+			if undeclared != "" {
+				node.Prefix = undeclared
+				node.NamespaceURI = ""
+			}
+			if p.recordSelfClosing {
+				node.selfClosed = bytes.HasSuffix(p.reader.Cache(), []byte("/>"))
 			}
 
 			if p.level == p.prev.level {
@@ -210,6 +929,9 @@ func (p *parser) parse() (*Node, error) {
 			p.level++
 		case xml.EndElement:
 			p.level--
+			if p.level == 1 {
+				p.rootClosed = true
+			}
 			// If we're in streaming mode, and we already have a potential streaming
 			// target node identified (p.streamNode != nil) then we need to check if
 			// this is the real one we want to return to caller.
@@ -250,7 +972,7 @@ func (p *parser) parse() (*Node, error) {
 			if bytes.HasPrefix(cached, []byte("<![CDATA[")) || bytes.HasPrefix(cached, []byte("![CDATA[")) {
 				nodeType = CharDataNode
 			}
-			node := &Node{Type: nodeType, Data: string(tok), level: p.level}
+			node := p.newNode(Node{Type: nodeType, Data: string(tok), level: p.level})
 			if p.level == p.prev.level {
 				AddSibling(p.prev, node)
 			} else if p.level > p.prev.level {
@@ -262,7 +984,7 @@ func (p *parser) parse() (*Node, error) {
 				AddSibling(p.prev.Parent, node)
 			}
 		case xml.Comment:
-			node := &Node{Type: CommentNode, Data: string(tok), level: p.level}
+			node := p.newNode(Node{Type: CommentNode, Data: string(tok), level: p.level})
 			if p.level == p.prev.level {
 				AddSibling(p.prev, node)
 			} else if p.level > p.prev.level {
@@ -277,12 +999,18 @@ func (p *parser) parse() (*Node, error) {
 			if p.prev.Type != DeclarationNode {
 				p.level++
 			}
-			node := &Node{Type: DeclarationNode, Data: tok.Target, level: p.level}
-			pairs := strings.Split(string(tok.Inst), " ")
-			for _, pair := range pairs {
-				pair = strings.TrimSpace(pair)
-				if i := strings.Index(pair, "="); i > 0 {
-					AddAttr(node, pair[:i], strings.Trim(pair[i+1:], `"'`))
+			node := p.newNode(Node{Type: DeclarationNode, Data: tok.Target, level: p.level})
+			if pairs, ok := parsePseudoAttrs(string(tok.Inst)); ok {
+				for _, pair := range pairs {
+					AddAttr(node, pair[0], pair[1])
+				}
+			} else {
+				pairs := strings.Split(string(tok.Inst), " ")
+				for _, pair := range pairs {
+					pair = strings.TrimSpace(pair)
+					if i := strings.Index(pair, "="); i > 0 {
+						AddAttr(node, pair[:i], strings.Trim(pair[i+1:], `"'`))
+					}
 				}
 			}
 			if p.level == p.prev.level {
@@ -297,7 +1025,10 @@ func (p *parser) parse() (*Node, error) {
 			}
 			p.prev = node
 		case xml.Directive:
-			node := &Node{Type: NotationNode, Data: string(tok), level: p.level}
+			if p.disableDTD && bytes.HasPrefix(bytes.TrimSpace(tok), []byte("DOCTYPE")) {
+				continue
+			}
+			node := p.newNode(Node{Type: NotationNode, Data: string(tok), level: p.level})
 			if p.level == p.prev.level {
 				AddSibling(p.prev, node)
 			} else if p.level > p.prev.level {
@@ -315,7 +1046,9 @@ func (p *parser) parse() (*Node, error) {
 // StreamParser enables loading and parsing an XML document in a streaming
 // fashion.
 type StreamParser struct {
-	p *parser
+	p     *parser
+	prune bool
+	keep  map[string]bool
 }
 
 // CreateStreamParser creates a StreamParser. Argument streamElementXPath is
@@ -395,13 +1128,13 @@ func CreateStreamParserWithCompileOptions(
 ) (*StreamParser, error) {
 	elemXPath, err := getQuery(streamElementXPath, compileOpts)
 	if err != nil {
-		return nil, fmt.Errorf("invalid streamElementXPath '%s', err: %s", streamElementXPath, err.Error())
+		return nil, fmt.Errorf("invalid streamElementXPath '%s', err: %s", streamElementXPath, compileError(err))
 	}
 	elemFilter := (*xpath.Expr)(nil)
 	if len(streamElementFilter) > 0 {
 		elemFilter, err = getQuery(streamElementFilter[0], compileOpts)
 		if err != nil {
-			return nil, fmt.Errorf("invalid streamElementFilter '%s', err: %s", streamElementFilter[0], err.Error())
+			return nil, fmt.Errorf("invalid streamElementFilter '%s', err: %s", streamElementFilter[0], compileError(err))
 		}
 	}
 	parser := createParser(r)
@@ -414,6 +1147,63 @@ func CreateStreamParserWithCompileOptions(
 	return sp, nil
 }
 
+// QueryFileStream streams the XML file at path and calls cb, for each
+// element matching elementXPath, with the nodes selected by relativeExpr
+// evaluated relative to that element, which may be none. Each element is
+// discarded once cb returns, with PruneCompleted, so memory stays flat for
+// huge files; the nodes passed to cb must not be kept. The processing
+// stops at the first error returned by cb.
+func QueryFileStream(path, elementXPath, relativeExpr string, cb func([]*Node) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return queryStream(f, elementXPath, relativeExpr, cb)
+}
+
+func queryStream(r io.Reader, elementXPath, relativeExpr string, cb func([]*Node) error) error {
+	exp, err := getQuery(relativeExpr, xpath.CompileOptions{})
+	if err != nil {
+		return err
+	}
+	sp, err := CreateStreamParser(r, elementXPath)
+	if err != nil {
+		return err
+	}
+	sp.PruneCompleted()
+	for {
+		n, err := sp.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = cb(QuerySelectorAll(n, exp)); err != nil {
+			return err
+		}
+	}
+}
+
+// PruneCompleted makes Read also remove the completed subtrees preceding
+// the ancestors of the target node, not only the previous target node and
+// its preceding siblings. The chain of ancestor elements of the target node,
+// including their attributes, is kept, so the inherited context stays
+// available through Node.Parent while memory stays flat for documents with
+// many records grouped under intermediate elements. Completed elements
+// whose local name is listed in keep are retained as context as well.
+//
+// As with the default pruning, XPath expressions relying on the position
+// of the target node among removed nodes will not work as expected.
+func (sp *StreamParser) PruneCompleted(keep ...string) {
+	sp.prune = true
+	sp.keep = make(map[string]bool, len(keep))
+	for _, name := range keep {
+		sp.keep[name] = true
+	}
+}
+
 // Read returns a target node that satisfies the XPath specified by caller at
 // StreamParser creation time. If there is no more satisfying target nodes after
 // reading the rest of the XML document, io.EOF will be returned. At any time,
@@ -437,5 +1227,24 @@ func (sp *StreamParser) Read() (*Node, error) {
 		sp.p.streamNode = nil
 		sp.p.streamNodePrev = nil
 	}
-	return sp.p.parse()
+	n, err := sp.p.parse()
+	if err == nil && sp.prune {
+		sp.pruneAncestorSiblings(n.Parent)
+	}
+	return n, err
+}
+
+// pruneAncestorSiblings removes the preceding siblings of n and of each of
+// its ancestors below the document element, unless they are retained
+// elements.
+func (sp *StreamParser) pruneAncestorSiblings(n *Node) {
+	for ; n != nil && n.Parent != nil && n.Parent.Type != DocumentNode; n = n.Parent {
+		for s := n.PrevSibling; s != nil; {
+			prev := s.PrevSibling
+			if s.Type != ElementNode || !sp.keep[s.Data] {
+				RemoveFromTree(s)
+			}
+			s = prev
+		}
+	}
 }
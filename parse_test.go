@@ -1,12 +1,22 @@
 package xmlquery
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"unicode"
 
 	"github.com/antchfx/xpath"
 )
@@ -53,6 +63,51 @@ func TestLoadURLSuccess(t *testing.T) {
 	}
 }
 
+func compress(t *testing.T, encoding, s string) []byte {
+	var b bytes.Buffer
+	var w io.WriteCloser
+	if encoding == "gzip" || encoding == "x-gzip" {
+		w = gzip.NewWriter(&b)
+	} else {
+		w = zlib.NewWriter(&b)
+	}
+	if _, err := io.WriteString(w, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestLoadURLCompressed(t *testing.T) {
+	s := `<?xml version="1.0"?><parent><child>1</child></parent>`
+	for _, encoding := range []string{"gzip", "x-gzip", "deflate"} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/xml")
+			w.Header().Set("Content-Encoding", encoding)
+			w.Write(compress(t, encoding, s))
+		}))
+		defer server.Close()
+		doc, err := LoadURL(server.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testValue(t, FindOne(doc, "//child").InnerText(), "1")
+	}
+}
+
+func TestParseCompressed(t *testing.T) {
+	s := `<?xml version="1.0"?><parent><child>1</child></parent>`
+	for _, data := range [][]byte{compress(t, "gzip", s), compress(t, "deflate", s), []byte(s)} {
+		doc, err := ParseCompressed(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testValue(t, FindOne(doc, "//child").InnerText(), "1")
+	}
+}
+
 func TestLoadURLFailure(t *testing.T) {
 	contentTypes := []string{
 		"application/pdf",
@@ -296,6 +351,112 @@ func TestParse(t *testing.T) {
 	testValue(t, books[0].OutputXMLWithOptions(WithOutputSelf(), WithoutPreserveSpace()), `<book><title lang="en">Harry Potter</title><price>29.99</price></book>`)
 }
 
+func TestParseLeadingBytes(t *testing.T) {
+	for _, s := range []string{
+		"\xEF\xBB\xBF<?xml version=\"1.0\"?><a>1</a>",
+		" \r\n\t<?xml version=\"1.0\"?><a>1</a>",
+		"\xEF\xBB\xBF \n<?xml version=\"1.0\"?><a>1</a>",
+		"\xEF\xBB\xBF<a>1</a>",
+	} {
+		for _, parse := range []func(io.Reader) (*Node, error){
+			Parse,
+			func(r io.Reader) (*Node, error) { return ParseWithOptions(r, ParserOptions{}) },
+		} {
+			doc, err := parse(strings.NewReader(s))
+			if err != nil {
+				t.Fatalf("parse %q: %v", s, err)
+			}
+			testTrue(t, doc.NextSibling == nil)
+			testValue(t, doc.FirstChild.Type, DeclarationNode)
+			testValue(t, FindOne(doc, "/a").InnerText(), "1")
+		}
+
+		sp, err := CreateStreamParserWithOptions(strings.NewReader(s), ParserOptions{}, "/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := sp.Read()
+		if err != nil {
+			t.Fatalf("stream parse %q: %v", s, err)
+		}
+		testValue(t, n.InnerText(), "1")
+	}
+}
+
+func TestParseFragment(t *testing.T) {
+	nodes, err := ParseFragment(strings.NewReader(`<a id="1">x</a><b/>text<!--c--><ns:d xmlns:ns="urn:d"/>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testValue(t, len(nodes), 5)
+	testValue(t, nodes[0].OutputXML(true), `<a id="1">x</a>`)
+	testValue(t, nodes[0].Level(), 1)
+	testValue(t, nodes[1].Data, "b")
+	testValue(t, nodes[2].Data, "text")
+	testValue(t, nodes[3].Type, CommentNode)
+	testValue(t, nodes[4].NamespaceURI, "urn:d")
+	for _, n := range nodes {
+		testTrue(t, n.Parent == nil && n.PrevSibling == nil && n.NextSibling == nil)
+	}
+	testValue(t, FindOne(nodes[0], "self::a/@id").InnerText(), "1")
+
+	_, err = ParseFragment(strings.NewReader(`<a>`))
+	testTrue(t, err != nil)
+}
+
+func TestParseAll(t *testing.T) {
+	s := `<?xml version="1.0" encoding="UTF-8"?>
+<event id="1"><msg>start</msg></event>
+<?xml version="1.0"?>
+<!-- second -->
+<event id="2" xmlns="urn:log"><msg>stop</msg></event>
+<event id="3"/>
+`
+	docs, err := ParseAll(strings.NewReader(s))
+	testTrue(t, err == nil)
+	testValue(t, len(docs), 3)
+	for i, doc := range docs {
+		verifyNodePointers(t, doc)
+		testValue(t, doc.Type, DocumentNode)
+		testValue(t, len(Find(doc, "/*")), 1)
+		testValue(t, FindOne(doc, "/*").SelectAttr("id"), fmt.Sprint(i+1))
+	}
+	testValue(t, docs[0].Encoding(), "UTF-8")
+	testValue(t, docs[1].Encoding(), "")
+	testValue(t, FindOne(docs[1], "//comment()").Data, " second ")
+	testValue(t, docs[1].RootNamespace(), "urn:log")
+	testValue(t, docs[2].RootNamespace(), "")
+	testValue(t, FindOne(docs[2], "/event").Level(), 1)
+
+	docs, err = ParseAll(strings.NewReader(""))
+	testTrue(t, err == nil && len(docs) == 0)
+	_, err = ParseAll(strings.NewReader(`<a/><b>`))
+	testTrue(t, err != nil)
+}
+
+func TestXMLDeclarationInfo(t *testing.T) {
+	doc := loadXML(`<?xml version="1.0" encoding="ISO-8859-1" standalone="yes"?><a><b/></a>`)
+	b := FindOne(doc, "//b")
+	testValue(t, doc.XMLVersion(), "1.0")
+	testValue(t, b.XMLVersion(), "1.0")
+	testValue(t, b.Encoding(), "ISO-8859-1")
+	standalone, ok := b.Standalone()
+	testTrue(t, standalone && ok)
+
+	doc = loadXML(`<?xml version='1.0' standalone='no'?><a/>`)
+	testValue(t, doc.Encoding(), "")
+	standalone, ok = doc.Standalone()
+	testTrue(t, !standalone && ok)
+
+	// the declaration added for a document without one
+	doc = loadXML(`<a/>`)
+	testValue(t, doc.XMLVersion(), "1.0")
+	_, ok = doc.Standalone()
+	testTrue(t, !ok)
+
+	testValue(t, (&Node{Type: ElementNode, Data: "x"}).XMLVersion(), "")
+}
+
 func TestMissDeclaration(t *testing.T) {
 	s := `<AAA>
 		<BBB></BBB>
@@ -330,6 +491,23 @@ func TestMissingNamespace(t *testing.T) {
 	}
 }
 
+func TestAllowUndeclaredNamespacePrefix(t *testing.T) {
+	s := `<root xmlns:a="urn:a"><myns:child myns:id="1" a:x="2">value 1</myns:child><a:child></a:child></root>`
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{AllowUndeclaredNamespacePrefix: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := FindOne(doc, "//myns:child")
+	testTrue(t, n != nil)
+	testValue(t, n.Prefix, "myns")
+	testValue(t, n.NamespaceURI, "")
+	testValue(t, n.Attr[0].NamespaceURI, "")
+	testValue(t, n.Attr[1].NamespaceURI, "urn:a")
+	testValue(t, n.SelectAttr("myns:id"), "1")
+	testValue(t, FindOne(doc, "//a:child").NamespaceURI, "urn:a")
+	testValue(t, FindOne(doc, "/root").OutputXML(true), s)
+}
+
 func TestTooNested(t *testing.T) {
 	s := `<?xml version="1.0" encoding="UTF-8"?>
 	<!-- comment here-->
@@ -563,6 +741,33 @@ func TestStreamParser_Success2(t *testing.T) {
 	}
 }
 
+func TestStreamParser_PruneCompleted(t *testing.T) {
+	s := `<root id="r"><header>h</header>
+		<group id="g1"><meta/><item>1</item><item>2</item></group>
+		<group id="g2"><meta/><item>3</item></group>
+	</root>`
+	sp, err := CreateStreamParser(strings.NewReader(s), "/root/group/item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sp.PruneCompleted("header")
+	var n *Node
+	for i := 0; i < 3; i++ {
+		if n, err = sp.Read(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	testValue(t, n.InnerText(), "3")
+	testValue(t, n.Parent.SelectAttr("id"), "g2")
+	testValue(t, n.Parent.Parent.SelectAttr("id"), "r")
+	root := n.Parent.Parent
+	verifyNodePointers(t, root)
+	testValue(t, root.OutputXMLWithOptions(WithOutputSelf(), WithoutPreserveSpace()),
+		`<root id="r"><header>h</header><group id="g2"><meta></meta><item>3</item></group></root>`)
+	_, err = sp.Read()
+	testValue(t, err, io.EOF)
+}
+
 func TestCDATA(t *testing.T) {
 	s := `
 	<AAA>
@@ -710,3 +915,269 @@ func TestStreamParser_XPathStrictEOFOption(t *testing.T) {
 		t.Fatalf("expected to find <a> node, got: %#v", n)
 	}
 }
+
+func FuzzParseRoundTrip(f *testing.F) {
+	for _, s := range []string{
+		xmlDoc,
+		`<?xml version="1.0" encoding="UTF-8"?><S:Envelope xmlns:S="http://schemas.xmlsoap.org/soap/envelope/"><S:Body></S:Body></S:Envelope>`,
+		`<a xmlns="urn:a" xmlns:b="urn:b"><b:c b:d="1"/><e/></a>`,
+		`<a>x &lt; y &amp; <![CDATA[<z>]]> &#xD;</a>`,
+		`<!DOCTYPE a><!--c--><a x='"' y="'"><?pi k="v"?></a>`,
+		`<a xml:space="preserve">  <b> </b>  </a>`,
+		`<?pi k="a&b" q='"'?><a/>`,
+	} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		doc, err := Parse(strings.NewReader(s))
+		if err != nil || !wellFormedNames(doc) {
+			return
+		}
+		output := doc.OutputXML(false)
+		doc2, err := Parse(strings.NewReader(output))
+		if err != nil {
+			t.Fatalf("cannot parse the output %q of %q: %v", output, s, err)
+		}
+		if !Equal(doc, doc2) {
+			t.Fatalf("%q and its output %q are not equal", s, output)
+		}
+	})
+}
+
+// wellFormedNames reports whether the names used in the tree are valid XML
+// names, as the decoder accepts some invalid ones, such as p:0.
+func wellFormedNames(n *Node) bool {
+	isName := func(s string) bool {
+		for i, r := range s {
+			if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r) && r != '-' && r != '.') {
+				return false
+			}
+		}
+		return s != ""
+	}
+	if n.Type == ElementNode {
+		if !isName(n.Data) || n.Prefix != "" && !isName(n.Prefix) {
+			return false
+		}
+		for _, attr := range n.Attr {
+			if !isName(attr.Name.Local) || attr.Name.Space == "xmlns" && attr.Value == "" {
+				return false
+			}
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if !wellFormedNames(child) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseWithWarnings(t *testing.T) {
+	s := `<html><body><p>AT&T &nbsp; &amp; &#38; &copy;<br>x<i>y</p><x:svg/><hr/></body></html>`
+	options := ParserOptions{Decoder: &DecoderOptions{
+		Strict:    false,
+		AutoClose: []string{"br"},
+		Entity:    map[string]string{"copy": "©"},
+	}}
+	doc, warnings, err := ParseWithWarnings(strings.NewReader(s), options)
+	testTrue(t, err == nil)
+	testValue(t, FindOne(doc, "//p").InnerText(), "AT&T &nbsp; & & ©xy")
+	var messages []string
+	for _, w := range warnings {
+		testTrue(t, w.Offset > 0 && w.Offset <= int64(len(s)))
+		messages = append(messages, w.Message)
+	}
+	expected := []string{
+		"unescaped & kept as text",
+		"unknown entity &nbsp; kept as text",
+		"element <br> closed automatically",
+		"element <i> closed automatically",
+		"namespace prefix x of element <svg> is not declared",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("expected warnings %q, but got %q", expected, messages)
+	}
+	testValue(t, warnings[0].String(), fmt.Sprintf("offset %d: unescaped & kept as text", warnings[0].Offset))
+
+	// well-formed documents have no warnings
+	_, warnings, err = ParseWithWarnings(strings.NewReader(`<a x="&lt;"><b>&amp;</b><c/><![CDATA[&x]]></a>`), options)
+	testTrue(t, err == nil && warnings == nil)
+	_, warnings, err = ParseWithWarnings(strings.NewReader(`<a><b>`), ParserOptions{})
+	testTrue(t, err != nil && warnings == nil)
+}
+
+func TestParseWithArena(t *testing.T) {
+	s := `<?xml version="1.0"?><root a="1"><!--c--><x>text</x><y><![CDATA[d]]></y><?pi k="v"?></root>`
+	arena := NewNodeArena(2)
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{Arena: arena})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := Parse(strings.NewReader(s))
+	testValue(t, doc.OutputXML(true), want.OutputXML(true))
+	testValue(t, arena.Len(), 8)
+	verifyNodePointers(t, doc)
+
+	var zero NodeArena
+	if _, err := ParseWithOptions(strings.NewReader(s), ParserOptions{Arena: &zero}); err != nil {
+		t.Fatal(err)
+	}
+	testValue(t, zero.Len(), 8)
+}
+
+func TestParseKeepRawAttrValues(t *testing.T) {
+	s := `<root a='single' b="x &amp; y" c="&#65;&#x42;" xmlns:p="urn:p"><item p:d = 'it&apos;s' e=""/></root>`
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{KeepRawAttrValues: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := FindOne(doc, "/root")
+	testValue(t, root.SelectAttr("c"), "AB")
+	testTrue(t, root.Attr[0] == Attr{Name: xml.Name{Local: "a"}, Value: "single"})
+	testValue(t, root.OutputXML(true), `<root a='single' b="x &amp; y" c="&#65;&#x42;" xmlns:p="urn:p"><item p:d='it&apos;s' e=""></item></root>`)
+
+	root.SetAttr("b", "z")
+	FindOne(doc, "//item").Attr[0].Value = "changed"
+	testValue(t, root.OutputXML(true), `<root a='single' b="z" c="&#65;&#x42;" xmlns:p="urn:p"><item p:d="changed" e=""></item></root>`)
+
+	doc, _ = Parse(strings.NewReader(s))
+	testValue(t, FindOne(doc, "/root").OutputXML(true), `<root a="single" b="x &amp; y" c="AB" xmlns:p="urn:p"><item p:d="it&#39;s" e=""></item></root>`)
+}
+
+func TestParseNormalizeAttrValues(t *testing.T) {
+	s := "<a x=\"one\ttwo\r\nthree\" y=\"keep&#10;&#x9;refs &amp;\tmore\" z='plain'/>"
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{NormalizeAttrValues: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := FindOne(doc, "/a")
+	testValue(t, a.SelectAttr("x"), "one two three")
+	testValue(t, a.SelectAttr("y"), "keep\n\trefs & more")
+	testValue(t, a.SelectAttr("z"), "plain")
+
+	doc, _ = Parse(strings.NewReader(s))
+	testValue(t, FindOne(doc, "/a").SelectAttr("x"), "one\ttwo\nthree")
+}
+
+func TestIsWellFormed(t *testing.T) {
+	for _, s := range []string{
+		`<a/>`,
+		"\xEF\xBB\xBF<?xml version=\"1.0\"?>\n<!-- c --><a><b x=\"1\"/></a>\n<?pi?>",
+		`<x:a xmlns:x="urn:x"><x:b x:c="1"/></x:a>`,
+		`<a><x:b xmlns:x="urn:x"/><x:b xmlns:x="urn:y"/></a>`,
+		`<a xmlns:p="urn:p" p:b="1" b="2"/>`,
+		`<?xml version="1.0" encoding="ISO-8859-1"?><a>text</a>`,
+	} {
+		ok, err := IsWellFormed(strings.NewReader(s))
+		if !ok || err != nil {
+			t.Errorf("%q: expected well-formed, got %v", s, err)
+		}
+	}
+	for _, s := range []string{
+		``,
+		`<a>`,
+		`<a></b>`,
+		`<a/><b/>`,
+		`text<a/>`,
+		`<a x="1" x="2"/>`,
+		`<x:a/>`,
+		`<a><x:b xmlns:x="urn:x"/><x:c/></a>`,
+		`<a xmlns:p="q"><q:b/></a>`,
+		`<a xmlns:p="q" q:b="1"/>`,
+		`<a xmlns:p="urn:p" xmlns:q="urn:p" p:b="1" q:b="2"/>`,
+		`<x:a xmlns:x="urn:x"></y:a>`,
+		`<a b=c/>`,
+	} {
+		ok, err := IsWellFormed(strings.NewReader(s))
+		if ok || err == nil {
+			t.Errorf("%q: expected not well-formed", s)
+		}
+	}
+}
+
+func TestQueryFileStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "xmlquery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "records.xml")
+	s := `<root><group id="g1"><record><name>a</name><tag>1</tag><tag>2</tag></record><record><name>b</name></record></group></root>`
+	if err := ioutil.WriteFile(path, []byte(s), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	err = QueryFileStream(path, "//record", "name | tag", func(nodes []*Node) error {
+		var values []string
+		for _, n := range nodes {
+			values = append(values, n.InnerText())
+		}
+		got = append(got, strings.Join(values, ","))
+		return nil
+	})
+	testTrue(t, err == nil)
+	testTrue(t, reflect.DeepEqual(got, []string{"a,1,2", "b"}))
+
+	errStop := errors.New("stop")
+	count := 0
+	err = QueryFileStream(path, "//record", "name", func([]*Node) error {
+		count++
+		return errStop
+	})
+	testTrue(t, err == errStop && count == 1)
+
+	testTrue(t, QueryFileStream(path, "//record", "name[", func([]*Node) error { return nil }) != nil)
+	testTrue(t, QueryFileStream(filepath.Join(dir, "missing.xml"), "//record", "name", func([]*Node) error { return nil }) != nil)
+}
+
+func TestParseRecordSelfClosing(t *testing.T) {
+	s := `<a><b/><c></c><d x="1" /><e>t</e></a>`
+	doc, err := ParseWithOptions(strings.NewReader(s), ParserOptions{RecordSelfClosing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testTrue(t, FindOne(doc, "//b").SelfClosed())
+	testTrue(t, !FindOne(doc, "//c").SelfClosed())
+	testTrue(t, FindOne(doc, "//d").SelfClosed())
+	testTrue(t, !FindOne(doc, "//a").SelfClosed())
+	a := FindOne(doc, "/a")
+	testValue(t, a.OutputXMLWithOptions(WithOutputSelf(), WithSourceEmptyTags()), `<a><b/><c></c><d x="1"/><e>t</e></a>`)
+	testValue(t, a.OutputXMLWithOptions(WithOutputSelf(), WithSourceEmptyTags(), WithEmptyTagSupport()), `<a><b/><c></c><d x="1"/><e>t</e></a>`)
+	testValue(t, a.OutputXMLWithOptions(WithOutputSelf(), WithEmptyTagSupport()), `<a><b/><c/><d x="1"/><e>t</e></a>`)
+
+	// an element given content is written with an end tag
+	AddChild(FindOne(doc, "//b"), &Node{Type: TextNode, Data: "new"})
+	testValue(t, a.OutputXMLWithOptions(WithOutputSelf(), WithSourceEmptyTags()), `<a><b>new</b><c></c><d x="1"/><e>t</e></a>`)
+
+	doc, _ = Parse(strings.NewReader(s))
+	testTrue(t, !FindOne(doc, "//b").SelfClosed())
+}
+
+func TestDocType(t *testing.T) {
+	doc := loadXML(`<?xml version="1.0"?>
+<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">
+<html><body/></html>`)
+	name, publicID, systemID, ok := FindOne(doc, "//body").DocType()
+	testTrue(t, ok)
+	testValue(t, name, "html")
+	testValue(t, publicID, "-//W3C//DTD XHTML 1.0 Strict//EN")
+	testValue(t, systemID, "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd")
+	testTrue(t, strings.Contains(doc.OutputXML(false), `<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd">`))
+
+	doc = loadXML(`<?xml version="1.0"?><!DOCTYPE note SYSTEM 'note.dtd' [<!ENTITY x "y">]><note/>`)
+	name, publicID, systemID, ok = doc.DocType()
+	testTrue(t, ok && name == "note" && publicID == "" && systemID == "note.dtd")
+
+	doc = loadXML(`<?xml version="1.0"?><!DOCTYPE root[<!ELEMENT root ANY>]><root/>`)
+	name, _, systemID, ok = doc.DocType()
+	testTrue(t, ok && name == "root" && systemID == "")
+
+	_, _, _, ok = loadXML(`<root/>`).DocType()
+	testTrue(t, !ok)
+
+	doc, _ = ParseWithOptions(strings.NewReader(`<?xml version="1.0"?><!DOCTYPE root><root/>`), ParserOptions{DisableDTD: true})
+	_, _, _, ok = doc.DocType()
+	testTrue(t, !ok)
+}
@@ -1,15 +1,31 @@
 /*
 Package xmlquery provides extract data from XML documents using XPath expression.
+
+Parsing relies on the encoding/xml package, which doesn't process DTDs: a
+<!DOCTYPE> declaration is kept as a NotationNode, external entities and
+DTDs are never loaded, and entities declared in a DTD are not expanded, so
+parsing doesn't access the network or the file system and isn't subject to
+XXE attacks. In strict mode, references to entities other than the
+predefined ones, or those given by DecoderOptions.Entity, are errors.
+ParserOptions.DisableDTD drops the DOCTYPE declarations altogether.
+Only LoadURL accesses the network, to get the document at the given URL.
 */
 package xmlquery
 
 import (
+	"encoding/xml"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/antchfx/xpath"
 )
 
+// ErrQueryTimeout is returned when the evaluation of a query exceeds
+// its allowed duration.
+var ErrQueryTimeout = errors.New("xmlquery: query timed out")
+
 // SelectElements finds child elements with the specified name.
 func (n *Node) SelectElements(name string) []*Node {
 	return Find(n, name)
@@ -20,7 +36,94 @@ func (n *Node) SelectElement(name string) *Node {
 	return FindOne(n, name)
 }
 
-// SelectAttr returns the attribute value with the specified name.
+// SelectElementsFold finds child elements whose name matches name without
+// regard to case. Names are compared with strings.EqualFold, that is under
+// simple Unicode case folding: "ITEM", "Item" and "item" all match, and so
+// do "ÉTÉ" and "été", but multi-character foldings such as "ß" to "ss" are
+// not applied. If name has a prefix, such as "ns:item", the prefix must
+// match as well; otherwise only the local name is compared.
+func (n *Node) SelectElementsFold(name string) []*Node {
+	var list []*Node
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != ElementNode {
+			continue
+		}
+		if strings.EqualFold(child.Data, name) || strings.IndexByte(name, ':') > 0 && strings.EqualFold(child.qualifiedName(), name) {
+			list = append(list, child)
+		}
+	}
+	return list
+}
+
+// SelectPath follows the first child element with each of the names in
+// turn and returns the element reached, or nil if a step has no match.
+// SelectPath("channel", "item", "title") returns the title of the first
+// item of the first channel. The names are not XPath expressions: if a
+// name has a prefix, such as "ns:item", the prefix must match as well;
+// otherwise only the local name is compared.
+func (n *Node) SelectPath(names ...string) *Node {
+	for _, name := range names {
+		var next *Node
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			if isElementNamed(child, name) {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		n = next
+	}
+	return n
+}
+
+// TextOf returns the InnerText of the first child element with each of the
+// names, by name, with an empty string for the names without a matching
+// child. The names are matched as by SelectPath.
+func (n *Node) TextOf(names ...string) map[string]string {
+	m := make(map[string]string, len(names))
+	for _, name := range names {
+		if _, ok := m[name]; ok {
+			continue
+		}
+		m[name] = ""
+		if e := n.SelectPath(name); e != nil {
+			m[name] = e.InnerText()
+		}
+	}
+	return m
+}
+
+// SelectElementsRange returns the child elements with the specified name
+// whose index among them, counting from 0, is in the half-open range
+// [start, end), such as the items 10 to 19 with start 10 and end 20. The
+// children past end are not visited. The name is matched as by SelectPath.
+func (n *Node) SelectElementsRange(name string, start, end int) []*Node {
+	var list []*Node
+	i := 0
+	for child := n.FirstChild; child != nil && i < end; child = child.NextSibling {
+		if !isElementNamed(child, name) {
+			continue
+		}
+		if i >= start {
+			list = append(list, child)
+		}
+		i++
+	}
+	return list
+}
+
+// isElementNamed reports whether n is an element with the local name
+// name or, if name has a prefix, with the qualified name name.
+func isElementNamed(n *Node, name string) bool {
+	return n.Type == ElementNode && (n.Data == name || strings.IndexByte(name, ':') > 0 && n.qualifiedName() == name)
+}
+
+// SelectAttr returns the attribute value with the specified name. If the
+// node has several attributes with that name, as documents parsed with
+// DuplicateAttrKeep may, the value of the first one in n.Attr, which is the
+// document order, is returned. Use SelectAllAttr to get all of them.
 func (n *Node) SelectAttr(name string) string {
 	if n.Type == AttributeNode {
 		if n.Data == name {
@@ -37,28 +140,128 @@ func (n *Node) SelectAttr(name string) string {
 	return ""
 }
 
+// SelectAllAttr returns the values of all the attributes with the
+// specified name, in the order of n.Attr. It returns more than one value
+// only for malformed documents with duplicate attributes.
+func (n *Node) SelectAllAttr(name string) []string {
+	if n.Type == AttributeNode {
+		if n.Data == name {
+			return []string{n.InnerText()}
+		}
+		return nil
+	}
+	var values []string
+	xmlName := newXMLName(name)
+	for _, attr := range n.Attr {
+		if attr.Name == xmlName {
+			values = append(values, attr.Value)
+		}
+	}
+	return values
+}
+
+// SelectAttrWithNS returns the value and the namespace URI of the attribute
+// with the specified name. ok reports whether the attribute exists, which
+// distinguishes an empty attribute from a missing one.
+func (n *Node) SelectAttrWithNS(name string) (value, namespaceURI string, ok bool) {
+	if n.Type == AttributeNode {
+		if n.Data == name {
+			return n.InnerText(), n.NamespaceURI, true
+		}
+		return "", "", false
+	}
+	xmlName := newXMLName(name)
+	for _, attr := range n.Attr {
+		if attr.Name == xmlName {
+			return attr.Value, attr.NamespaceURI, true
+		}
+	}
+	return "", "", false
+}
+
+// AttrsInNamespace returns the attributes of the node whose namespace URI
+// is uri, whatever their prefix. An empty uri selects the attributes that
+// are in no namespace. Namespace declarations are never returned.
+func (n *Node) AttrsInNamespace(uri string) []Attr {
+	return n.AttrsInNamespaceWithOptions(uri, NSMatchOptions{})
+}
+
+// AttrsInNamespaceWithOptions is like AttrsInNamespace, with the namespace
+// URIs compared as by MatchNamespace with opts.
+func (n *Node) AttrsInNamespaceWithOptions(uri string, opts NSMatchOptions) []Attr {
+	var list []Attr
+	for _, attr := range n.Attr {
+		if attr.Name.Space == "xmlns" || attr.Name.Space == "" && attr.Name.Local == "xmlns" {
+			continue
+		}
+		if MatchNamespace(attr.NamespaceURI, uri, opts) {
+			list = append(list, attr)
+		}
+	}
+	return list
+}
+
+// AttrValues returns the value of the attribute with the specified name of
+// each of the nodes, in order, with an empty string for the nodes that
+// don't have it. Use PresentAttrValues to skip these nodes instead.
+func AttrValues(nodes []*Node, name string) []string {
+	values := make([]string, len(nodes))
+	for i, n := range nodes {
+		values[i] = n.SelectAttr(name)
+	}
+	return values
+}
+
+// PresentAttrValues returns the value of the attribute with the specified
+// name of each of the nodes that have it, in order.
+func PresentAttrValues(nodes []*Node, name string) []string {
+	var values []string
+	for _, n := range nodes {
+		if v, _, ok := n.SelectAttrWithNS(name); ok {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
 var _ xpath.NodeNavigator = &NodeNavigator{}
 
 // CreateXPathNavigator creates a new xpath.NodeNavigator for the specified
 // XML Node.
+//
+// The node is both the context node and the root of the queries: absolute
+// location paths start from it, so for a detached element, such as one
+// returned by ParseFragment or removed from its tree, "/" selects the
+// element itself and "/b" and "//b" select its child and descendant b
+// elements, as "b" and ".//b" do. Queries from a node with a parent work
+// the same, except that axes such as ancestor:: still reach the nodes
+// above it.
 func CreateXPathNavigator(top *Node) *NodeNavigator {
 	return &NodeNavigator{curr: top, root: top, attr: -1}
 }
 
+// CreateXPathNavigatorWithRoot creates a new xpath.NodeNavigator whose
+// context node is context and whose root, where absolute location paths
+// start, is root, which should be context or one of its ancestors. A nil
+// root selects the root of the tree of context, so "/catalog" finds the
+// document element of the document of context.
+func CreateXPathNavigatorWithRoot(root, context *Node) *NodeNavigator {
+	if root == nil {
+		root = GetRoot(context)
+	}
+	return &NodeNavigator{curr: context, root: root, attr: -1}
+}
+
 func getCurrentNode(it *xpath.NodeIterator) *Node {
-	n := it.Current().(*NodeNavigator)
+	var n *NodeNavigator
+	switch x := it.Current().(type) {
+	case *NodeNavigator:
+		n = x
+	case *watchedNavigator:
+		n = x.NodeNavigator
+	}
 	if n.NodeType() == xpath.AttributeNode {
-		childNode := &Node{
-			Type: TextNode,
-			Data: n.Value(),
-		}
-		return &Node{
-			Parent:     n.curr,
-			Type:       AttributeNode,
-			Data:       n.LocalName(),
-			FirstChild: childNode,
-			LastChild:  childNode,
-		}
+		return n.curr.attributeNode(n.attr)
 	}
 	return n.curr
 }
@@ -111,6 +314,280 @@ func Query(top *Node, expr string) (*Node, error) {
 	return QueryWithOptions(top, expr, xpath.CompileOptions{})
 }
 
+// QueryAllEach calls fn for each node that matches the specified XPath
+// expr, as the matches are found, and stops when fn returns false. Nodes
+// beyond the last one passed to fn are not searched for.
+// Returns an error if the expression `expr` cannot be parsed.
+func QueryAllEach(top *Node, expr string, fn func(*Node) bool) error {
+	exp, err := getQuery(expr, xpath.CompileOptions{})
+	if err != nil {
+		return err
+	}
+	t := exp.Select(CreateXPathNavigator(top))
+	for t.MoveNext() {
+		if !fn(getCurrentNode(t)) {
+			break
+		}
+	}
+	return nil
+}
+
+// QueryMap returns the result of project applied to each node that matches
+// the specified XPath expr, in the order of the matches, without building
+// the list of nodes first.
+// Returns an error if the expression `expr` cannot be parsed.
+func QueryMap(top *Node, expr string, project func(*Node) string) ([]string, error) {
+	var values []string
+	err := QueryAllEach(top, expr, func(n *Node) bool {
+		values = append(values, project(n))
+		return true
+	})
+	return values, err
+}
+
+// RenameAll renames the elements that match the specified XPath expr to
+// newName, as by Node.Rename, and returns the number of elements renamed.
+// The other nodes matched are left unchanged.
+// Returns an error if the expression `expr` cannot be parsed.
+func RenameAll(top *Node, expr, newName string) (int, error) {
+	nodes, err := QueryAll(top, expr)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, n := range nodes {
+		if n.Type == ElementNode {
+			n.Rename(newName)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// QueryAllUnique returns the nodes that match the specified XPath expr,
+// keeping only the first of the nodes for which key returns the same
+// value, such as their XPath or their InnerText. A nil key keeps one of
+// each node or attribute, as QueryOptions.Dedup.
+// Returns an error if the expression `expr` cannot be parsed.
+func QueryAllUnique(top *Node, expr string, key func(*Node) string) ([]*Node, error) {
+	exp, err := getQuery(expr, xpath.CompileOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return QuerySelectorAllWith(top, exp, QueryOptions{Dedup: true, DedupKey: key}), nil
+}
+
+// QueryAllFrom evaluates the XPath expr relative to each node in nodes and
+// returns the combined matches, without duplicates, in document order.
+// Returns an error if the expression `expr` cannot be parsed.
+func QueryAllFrom(nodes []*Node, expr string) ([]*Node, error) {
+	exp, err := getQuery(expr, xpath.CompileOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var elems []*Node
+	for _, n := range nodes {
+		elems = append(elems, QuerySelectorAll(n, exp)...)
+	}
+	return documentOrder(elems), nil
+}
+
+// nodeKey identifies a node for deduplication. Attribute nodes are keyed
+// by their owner element and qualified name and namespace, which also
+// matches attribute nodes that were not returned by a query.
+type nodeKey struct {
+	n         *Node
+	attr      xml.Name
+	attrSpace string
+}
+
+func keyOf(n *Node) nodeKey {
+	if n.Type == AttributeNode {
+		return nodeKey{n: n.Parent, attr: xml.Name{Space: n.Prefix, Local: n.Data}, attrSpace: n.NamespaceURI}
+	}
+	return nodeKey{n: n}
+}
+
+// documentOrder removes duplicates from list and sorts it in document
+// order. Nodes from different trees are grouped by tree, in the order
+// each tree first appears in list.
+func documentOrder(list []*Node) []*Node {
+	if len(list) < 2 {
+		return list
+	}
+	seen := make(map[nodeKey]*Node, len(list))
+	var roots []*Node
+	for _, n := range list {
+		k := keyOf(n)
+		if _, ok := seen[k]; ok {
+			continue
+		}
+		seen[k] = n
+		root := GetRoot(k.n)
+		found := false
+		for _, r := range roots {
+			if r == root {
+				found = true
+				break
+			}
+		}
+		if !found {
+			roots = append(roots, root)
+		}
+	}
+	result := make([]*Node, 0, len(seen))
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if v, ok := seen[nodeKey{n: n}]; ok {
+			result = append(result, v)
+		}
+		for _, attr := range n.Attr {
+			k := nodeKey{n: n, attr: attr.Name, attrSpace: attr.NamespaceURI}
+			if v, ok := seen[k]; ok {
+				result = append(result, v)
+				delete(seen, k)
+			}
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	for _, root := range roots {
+		walk(root)
+	}
+	return result
+}
+
+// QueryAllTimeout is like QueryAll, but returns ErrQueryTimeout if the
+// evaluation of `expr` takes longer than d.
+func QueryAllTimeout(top *Node, expr string, d time.Duration) ([]*Node, error) {
+	exp, err := getQuery(expr, xpath.CompileOptions{})
+	if err != nil {
+		return nil, err
+	}
+	w := &navigatorWatch{deadline: time.Now().Add(d)}
+	return w.selectAll(top, exp)
+}
+
+// QueryStats reports the work done while evaluating a query.
+type QueryStats struct {
+	// NodesVisited is the number of navigator moves made during the
+	// evaluation.
+	NodesVisited int
+	// Duration is the wall-clock time of the evaluation.
+	Duration time.Duration
+}
+
+// QueryAllWithStats is like QueryAll, but also reports statistics about
+// the evaluation of `expr`.
+func QueryAllWithStats(top *Node, expr string) ([]*Node, QueryStats, error) {
+	exp, err := getQuery(expr, xpath.CompileOptions{})
+	if err != nil {
+		return nil, QueryStats{}, err
+	}
+	w := &navigatorWatch{}
+	start := time.Now()
+	elems, err := w.selectAll(top, exp)
+	return elems, QueryStats{NodesVisited: w.visited, Duration: time.Since(start)}, err
+}
+
+// ParseError is returned by ParseQuery when the document cannot be parsed.
+type ParseError struct {
+	Err error
+}
+
+func (e *ParseError) Error() string { return e.Err.Error() }
+
+func (e *ParseError) Unwrap() error { return e.Err }
+
+// QueryError is returned by the query functions when an XPath expression
+// cannot be compiled. Err is the error returned by the xpath package.
+type QueryError struct {
+	Expr string
+	Err  error
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("xmlquery: invalid expression '%s': %s", e.Expr, e.Err)
+}
+
+func (e *QueryError) Unwrap() error { return e.Err }
+
+// compileError returns the error of the xpath package wrapped in err, if
+// err is a *QueryError, or err itself.
+func compileError(err error) error {
+	var qe *QueryError
+	if errors.As(err, &qe) {
+		return qe.Err
+	}
+	return err
+}
+
+// ParseQuery parses the XML document in data and returns the nodes
+// matching the XPath expr. A *ParseError is returned if the document is
+// invalid and a *QueryError if the expression is.
+func ParseQuery(data []byte, expr string) ([]*Node, error) {
+	doc, err := ParseBytes(data)
+	if err != nil {
+		return nil, &ParseError{Err: err}
+	}
+	return QueryAll(doc, expr)
+}
+
+// Closest returns the nearest node among the current node and its ancestors
+// that matches the XPath expr, or nil if there is none. A node matches if
+// it's selected by expr evaluated relative to its parent, so a name test
+// with predicates such as `book[@id]` matches the book elements having an
+// id attribute. The root of a tree without a document node, such as a node
+// returned by ParseFragment or removed with RemoveFromTree, is tested as
+// the only child of a document. Returns an error if the expression `expr`
+// cannot be parsed.
+func (n *Node) Closest(expr string) (*Node, error) {
+	exp, err := getQuery(expr, xpath.CompileOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for a := n; a != nil && a.Type != DocumentNode; a = a.Parent {
+		parent := a.Parent
+		if parent == nil {
+			// The node is not linked to this parent, so it stays detached.
+			parent = &Node{Type: DocumentNode, FirstChild: a, LastChild: a}
+		}
+		t := exp.Select(CreateXPathNavigator(parent))
+		for t.MoveNext() {
+			if getCurrentNode(t) == a {
+				return a, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Has reports whether the subtree of n contains a node matching the XPath
+// expr, like boolean(.//expr): expr is evaluated relative to n and to each
+// of its descendants, with n as the root of absolute paths, and only the
+// nodes selected in the subtree of n, n included, count. `book.Has("price")`
+// is true if a price element is anywhere below the book, while
+// `book.Has("ancestor::catalog")` is false. The evaluation stops at the
+// first match. Returns an error if the expression `expr` cannot be parsed.
+func (n *Node) Has(expr string) (bool, error) {
+	exp, err := getQuery(expr, xpath.CompileOptions{})
+	if err != nil {
+		return false, err
+	}
+	for d := n; d != nil; d = nextDescendant(n, d) {
+		t := exp.Select(CreateXPathNavigatorWithRoot(n, d))
+		for t.MoveNext() {
+			for a := getCurrentNode(t); a != nil; a = a.Parent {
+				if a == n {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
 // QuerySelectorAll searches all of the XML Node that matches the specified
 // XPath selectors.
 func QuerySelectorAll(top *Node, selector *xpath.Expr) []*Node {
@@ -122,6 +599,62 @@ func QuerySelectorAll(top *Node, selector *xpath.Expr) []*Node {
 	return elems
 }
 
+// QueryOptions controls how QuerySelectorAllWith collects the matched
+// nodes.
+type QueryOptions struct {
+	// Limit is the maximum number of nodes returned. The evaluation stops
+	// as soon as it's reached. Zero means no limit.
+	Limit int
+	// Dedup removes the nodes already matched, keeping the first
+	// occurrence.
+	Dedup bool
+	// DedupKey, if not nil, defines which nodes are the same for Dedup:
+	// the nodes for which it returns the same key. By default, nodes are
+	// the same if they are the same node or the same attribute of an
+	// element.
+	DedupKey func(*Node) string
+	// Reverse returns the matched nodes in reverse order. It's applied
+	// after Limit, so the first Limit matches are returned, last first.
+	Reverse bool
+}
+
+// QuerySelectorAllWith is like QuerySelectorAll, but collects the matched
+// nodes according to opts.
+func QuerySelectorAllWith(top *Node, selector *xpath.Expr, opts QueryOptions) []*Node {
+	t := selector.Select(CreateXPathNavigator(top))
+	var seen map[nodeKey]bool
+	var seenKeys map[string]bool
+	if opts.Dedup && opts.DedupKey != nil {
+		seenKeys = make(map[string]bool)
+	} else if opts.Dedup {
+		seen = make(map[nodeKey]bool)
+	}
+	var elems []*Node
+	for (opts.Limit <= 0 || len(elems) < opts.Limit) && t.MoveNext() {
+		n := getCurrentNode(t)
+		if seenKeys != nil {
+			k := opts.DedupKey(n)
+			if seenKeys[k] {
+				continue
+			}
+			seenKeys[k] = true
+		} else if seen != nil {
+			k := keyOf(n)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		elems = append(elems, n)
+	}
+	if opts.Reverse {
+		for i, j := 0, len(elems)-1; i < j; i, j = i+1, j-1 {
+			elems[i], elems[j] = elems[j], elems[i]
+		}
+	}
+	return elems
+}
+
 // QuerySelector returns the first matched XML Node by the specified XPath
 // selector.
 func QuerySelector(top *Node, selector *xpath.Expr) *Node {
@@ -132,6 +665,47 @@ func QuerySelector(top *Node, selector *xpath.Expr) *Node {
 	return nil
 }
 
+// A Selector is a compiled XPath expression that can be used to query
+// many nodes without being compiled again.
+type Selector struct {
+	expr *xpath.Expr
+}
+
+// NewSelector compiles the XPath expr into a Selector.
+// Returns a *QueryError if the expression `expr` cannot be parsed.
+func NewSelector(expr string) (*Selector, error) {
+	exp, err := getQuery(expr, xpath.CompileOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &Selector{expr: exp}, nil
+}
+
+// MustCompile is like NewSelector but panics if `expr` is not a valid
+// XPath expression.
+func MustCompile(expr string) *Selector {
+	s, err := NewSelector(expr)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Find returns all the nodes matching the selector.
+func (s *Selector) Find(top *Node) []*Node {
+	return QuerySelectorAll(top, s.expr)
+}
+
+// FindOne returns the first node matching the selector.
+func (s *Selector) FindOne(top *Node) *Node {
+	return QuerySelector(top, s.expr)
+}
+
+// String returns the XPath expression of the selector.
+func (s *Selector) String() string {
+	return s.expr.String()
+}
+
 // FindEach searches the html.Node and calls functions cb.
 // Important: this method is deprecated, instead, use for .. = range Find(){}.
 func FindEach(top *Node, expr string, cb func(int, *Node)) {
@@ -256,17 +830,23 @@ func (x *NodeNavigator) MoveToChild() bool {
 	return false
 }
 
+// MoveToFirst moves to the first sibling of the current node. Like
+// MoveToNext and MoveToPrevious it skips whitespace-only text nodes, so
+// that last() and position() count the same siblings.
 func (x *NodeNavigator) MoveToFirst() bool {
-	if x.attr != -1 || x.curr.PrevSibling == nil {
+	if x.attr != -1 {
 		return false
 	}
-	for {
-		node := x.curr.PrevSibling
-		if node == nil {
-			break
+	first := x.curr
+	for node := x.curr.PrevSibling; node != nil; node = node.PrevSibling {
+		if node.Type != TextNode || strings.TrimSpace(node.Data) != "" {
+			first = node
 		}
-		x.curr = node
 	}
+	if first == x.curr {
+		return false
+	}
+	x.curr = first
 	return true
 }
 
@@ -310,3 +890,101 @@ func (x *NodeNavigator) MoveTo(other xpath.NodeNavigator) bool {
 	x.attr = node.attr
 	return true
 }
+
+// timeoutCheckInterval is the number of navigator moves between two
+// deadline checks.
+const timeoutCheckInterval = 256
+
+type queryTimeout struct{}
+
+// navigatorWatch observes the moves of a watchedNavigator. It is only used
+// by the query variants that need it, so the plain NodeNavigator is not
+// slowed down.
+type navigatorWatch struct {
+	deadline time.Time
+	visited  int
+}
+
+func (w *navigatorWatch) step() {
+	w.visited++
+	if w.visited%timeoutCheckInterval == 0 {
+		w.checkDeadline()
+	}
+}
+
+func (w *navigatorWatch) checkDeadline() {
+	if !w.deadline.IsZero() && time.Now().After(w.deadline) {
+		panic(queryTimeout{})
+	}
+}
+
+func (w *navigatorWatch) selectAll(top *Node, selector *xpath.Expr) (elems []*Node, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(queryTimeout); !ok {
+				panic(r)
+			}
+			elems, err = nil, ErrQueryTimeout
+		}
+	}()
+	t := selector.Select(&watchedNavigator{NodeNavigator: CreateXPathNavigator(top), watch: w})
+	for t.MoveNext() {
+		w.checkDeadline()
+		elems = append(elems, getCurrentNode(t))
+	}
+	return elems, nil
+}
+
+// watchedNavigator is a NodeNavigator that reports each move to a
+// navigatorWatch.
+type watchedNavigator struct {
+	*NodeNavigator
+	watch *navigatorWatch
+}
+
+func (x *watchedNavigator) Copy() xpath.NodeNavigator {
+	n := *x.NodeNavigator
+	return &watchedNavigator{NodeNavigator: &n, watch: x.watch}
+}
+
+func (x *watchedNavigator) MoveToRoot() {
+	x.watch.step()
+	x.NodeNavigator.MoveToRoot()
+}
+
+func (x *watchedNavigator) MoveToParent() bool {
+	x.watch.step()
+	return x.NodeNavigator.MoveToParent()
+}
+
+func (x *watchedNavigator) MoveToNextAttribute() bool {
+	x.watch.step()
+	return x.NodeNavigator.MoveToNextAttribute()
+}
+
+func (x *watchedNavigator) MoveToChild() bool {
+	x.watch.step()
+	return x.NodeNavigator.MoveToChild()
+}
+
+func (x *watchedNavigator) MoveToFirst() bool {
+	x.watch.step()
+	return x.NodeNavigator.MoveToFirst()
+}
+
+func (x *watchedNavigator) MoveToNext() bool {
+	x.watch.step()
+	return x.NodeNavigator.MoveToNext()
+}
+
+func (x *watchedNavigator) MoveToPrevious() bool {
+	x.watch.step()
+	return x.NodeNavigator.MoveToPrevious()
+}
+
+func (x *watchedNavigator) MoveTo(other xpath.NodeNavigator) bool {
+	if o, ok := other.(*watchedNavigator); ok {
+		other = o.NodeNavigator
+	}
+	return x.NodeNavigator.MoveTo(other)
+}
@@ -1,9 +1,12 @@
 package xmlquery
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/antchfx/xpath"
 )
@@ -236,3 +239,474 @@ func TestQueryAllWithOptions_XPathStrictEOFOption(t *testing.T) {
 		t.Fatalf("expected to find three <book> nodes, got: %#v", nodes)
 	}
 }
+
+func TestQueryDetachedElement(t *testing.T) {
+	ids := func(list []*Node) string {
+		var s []string
+		for _, n := range list {
+			s = append(s, n.Data+n.SelectAttr("id"))
+		}
+		return strings.Join(s, ",")
+	}
+	doc := loadXML(`<r><a id="1"><a id="2"/><b><a id="3"/></b></a></r>`)
+	removed := FindOne(doc, "/r/a")
+	RemoveFromTree(removed)
+	fragment, err := ParseFragment(strings.NewReader(`<a id="1"><a id="2"/><b><a id="3"/></b></a><c/>`))
+	testTrue(t, err == nil)
+
+	for _, a := range []*Node{removed, fragment[0]} {
+		testValue(t, ids(Find(a, "/")), "a1")
+		testValue(t, ids(Find(a, "/a")), "a2")
+		testValue(t, ids(Find(a, "a")), "a2")
+		testValue(t, ids(Find(a, "//a")), "a2,a3")
+		testValue(t, ids(Find(a, ".//a")), "a2,a3")
+		testValue(t, ids(Find(a, "/b/a")), "a3")
+		testValue(t, ids(documentOrder(Find(a, "//a[@id='3']/ancestor::*"))), "a1,b")
+		testValue(t, ids(Find(a, "/..")), "")
+		testValue(t, ids(Find(FindOne(a, "//b"), "/")), "b")
+	}
+	// a node with a parent: the same, but ancestors are reachable
+	b := FindOne(loadXML(`<r><b id="1"><a/></b></r>`), "//b")
+	testValue(t, ids(Find(b, "/a")), "a")
+	testValue(t, ids(Find(b, "ancestor::*")), "r")
+}
+
+func TestQueryAllEach(t *testing.T) {
+	var ids []string
+	err := QueryAllEach(doc, "//book", func(n *Node) bool {
+		ids = append(ids, n.SelectAttr("id"))
+		return true
+	})
+	testTrue(t, err == nil)
+	testValue(t, strings.Join(ids, ","), "bk101,bk102,bk103")
+
+	calls := 0
+	err = QueryAllEach(doc, "//book/*", func(n *Node) bool {
+		calls++
+		return n.Data != "title"
+	})
+	testTrue(t, err == nil)
+	testValue(t, calls, 2)
+
+	testTrue(t, QueryAllEach(doc, "//book[", func(*Node) bool { return true }) != nil)
+}
+
+func TestQueryAllFrom(t *testing.T) {
+	books := Find(doc, "//book")
+	list, err := QueryAllFrom([]*Node{books[2], books[0], books[0]}, "title")
+	testTrue(t, err == nil)
+	testValue(t, len(list), 2)
+	testValue(t, list[0].InnerText(), "XML Developer's Guide")
+	testValue(t, list[1].InnerText(), "Maeve Ascendant")
+
+	// overlapping contexts must not produce duplicates
+	list, err = QueryAllFrom([]*Node{doc, books[1]}, "//book/@id")
+	testTrue(t, err == nil)
+	testValue(t, len(list), 3)
+	testValue(t, list[0].InnerText(), "bk101")
+	testValue(t, list[2].InnerText(), "bk103")
+
+	// attributes with the same local name are distinct
+	a := loadXML(`<a xmlns:x="urn:x" id="1" x:id="2"/>`).SelectElement("a")
+	list, err = QueryAllFrom([]*Node{a, a}, "@*[local-name()='id']")
+	testTrue(t, err == nil)
+	testValue(t, len(list), 2)
+	testValue(t, list[0].InnerText(), "1")
+	testValue(t, list[1].InnerText(), "2")
+
+	if _, err = QueryAllFrom(books, "[invalid"); err == nil {
+		t.Fatal("expected an error for invalid expression")
+	}
+}
+
+func TestContextPositionFromNode(t *testing.T) {
+	books := Find(doc, "//book")
+	// the catalog comment precedes the books
+	for i, b := range books {
+		nav := CreateXPathNavigator(b)
+		testValue(t, xpath.MustCompile("position()").Evaluate(nav), float64(i+2))
+		testValue(t, xpath.MustCompile("last()").Evaluate(nav.Copy()), float64(4))
+	}
+	testValue(t, len(Find(books[1], "self::book[position()=2]")), 1)
+	testValue(t, len(Find(books[2], "self::node()[position()=last()]")), 1)
+	testValue(t, len(Find(books[1], "self::node()[position()=last()]")), 0)
+
+	list, err := QueryAllFrom(books, "self::*[position()=last()]")
+	testTrue(t, err == nil)
+	testValue(t, len(list), 1)
+	testValue(t, list[0].SelectAttr("id"), "bk103")
+
+	c := NewCompactDocument(doc)
+	last, _ := c.Root().Query("//book[3]")
+	testValue(t, xpath.MustCompile("last()").Evaluate(CreateCompactNavigator(last)), float64(4))
+}
+
+func TestQueryAllTimeout(t *testing.T) {
+	list, err := QueryAllTimeout(doc, "//book[@id]", time.Minute)
+	testTrue(t, err == nil)
+	testValue(t, len(list), 3)
+
+	var b strings.Builder
+	b.WriteString("<root>")
+	for i := 0; i < 200; i++ {
+		b.WriteString("<a><b><c/></b></a>")
+	}
+	b.WriteString("</root>")
+	large := loadXML(b.String())
+	_, err = QueryAllTimeout(large, "//*[count(//*//*//*) > 0]", time.Millisecond)
+	testValue(t, err, ErrQueryTimeout)
+}
+
+func TestQueryAllWithStats(t *testing.T) {
+	list, stats, err := QueryAllWithStats(doc, "/catalog/book[1]")
+	testTrue(t, err == nil)
+	testValue(t, len(list), 1)
+	narrow := stats.NodesVisited
+	testTrue(t, narrow > 0)
+
+	list, stats, err = QueryAllWithStats(doc, "//*")
+	testTrue(t, err == nil)
+	testValue(t, len(list), 22)
+	testTrue(t, stats.NodesVisited > narrow)
+
+	_, _, err = QueryAllWithStats(doc, "[invalid")
+	testTrue(t, err != nil)
+}
+
+func TestQuerySelectorAllWith(t *testing.T) {
+	selector := xpath.MustCompile("//book/@id")
+	list := QuerySelectorAllWith(doc, selector, QueryOptions{Limit: 2, Reverse: true})
+	testValue(t, len(list), 2)
+	testValue(t, list[0].InnerText(), "bk102")
+	testValue(t, list[1].InnerText(), "bk101")
+
+	// the parent of each book is returned once per book
+	selector = xpath.MustCompile("//book/..")
+	testValue(t, len(QuerySelectorAllWith(doc, selector, QueryOptions{})), 3)
+	list = QuerySelectorAllWith(doc, selector, QueryOptions{Dedup: true})
+	testValue(t, len(list), 1)
+	testValue(t, list[0].Data, "catalog")
+
+	selector = xpath.MustCompile("//book/title")
+	list = QuerySelectorAllWith(doc, selector, QueryOptions{Reverse: true})
+	testValue(t, len(list), 3)
+	testValue(t, list[0].InnerText(), "Maeve Ascendant")
+}
+
+func TestParseQuery(t *testing.T) {
+	list, err := ParseQuery([]byte(`<a><b>1</b><b>2</b></a>`), "//b")
+	testTrue(t, err == nil)
+	testValue(t, len(list), 2)
+
+	_, err = ParseQuery([]byte(`<a><b></a>`), "//b")
+	var parseErr *ParseError
+	testTrue(t, errors.As(err, &parseErr))
+
+	_, err = ParseQuery([]byte(`<a/>`), "//b[")
+	var queryErr *QueryError
+	testTrue(t, errors.As(err, &queryErr))
+	testValue(t, queryErr.Expr, "//b[")
+	testTrue(t, !errors.As(err, &parseErr))
+}
+
+func TestSelectElementsFold(t *testing.T) {
+	doc := loadXML(`<root xmlns:ns="urn:ns"><Item>1</Item><ITEM>2</ITEM><item>3</item><ns:ITEM>4</ns:ITEM><ÉTÉ>5</ÉTÉ><items/></root>`)
+	root := FindOne(doc, "/root")
+	testValue(t, len(root.SelectElementsFold("item")), 4)
+	testValue(t, len(root.SelectElements("item")), 1)
+	list := root.SelectElementsFold("NS:item")
+	testValue(t, len(list), 1)
+	testValue(t, list[0].InnerText(), "4")
+	testValue(t, len(root.SelectElementsFold("été")), 1)
+}
+
+func TestClosest(t *testing.T) {
+	author := FindOne(doc, "//book[@id='bk102']/author")
+	n, err := author.Closest("book[@id]")
+	testTrue(t, err == nil)
+	testValue(t, n.SelectAttr("id"), "bk102")
+
+	n, err = author.Closest("author")
+	testTrue(t, err == nil && n == author)
+
+	n, err = author.Closest("*[@id='bk101']")
+	testTrue(t, err == nil && n == nil)
+
+	n, err = author.Closest("/catalog")
+	testTrue(t, err == nil)
+	testValue(t, n.Data, "catalog")
+
+	_, err = author.Closest("book[")
+	testTrue(t, err != nil)
+
+	// the root of a detached tree is tested too
+	frag, err := ParseFragment(strings.NewReader(`<a id="1"><b/></a><c/>`))
+	testTrue(t, err == nil)
+	n, err = frag[0].Closest("a")
+	testTrue(t, err == nil && n == frag[0])
+	n, err = frag[0].FirstChild.Closest("a[@id]")
+	testTrue(t, err == nil && n == frag[0])
+	n, err = frag[0].FirstChild.Closest("c")
+	testTrue(t, err == nil && n == nil)
+	testTrue(t, frag[0].Parent == nil && frag[0].NextSibling == nil)
+
+	d := loadXML(`<r><a><b/></a></r>`)
+	a := FindOne(d, "//a")
+	RemoveFromTree(a)
+	n, err = a.SelectElement("b").Closest("a")
+	testTrue(t, err == nil && n == a)
+	n, err = a.Closest("r")
+	testTrue(t, err == nil && n == nil)
+}
+
+func TestHas(t *testing.T) {
+	book := FindOne(doc, "//book[@id='bk102']")
+	ok, err := book.Has(".//price[. > 5]")
+	testTrue(t, err == nil && ok)
+
+	ok, err = book.Has("author")
+	testTrue(t, err == nil && ok)
+
+	// like .//expr, a match at any depth counts
+	d := loadXML(`<r><book><info><price>5</price></info></book></r>`)
+	ok, err = FindOne(d, "//book").Has("price")
+	testTrue(t, err == nil && ok)
+	ok, err = FindOne(d, "//book").Has("price[. > 5]")
+	testTrue(t, err == nil && !ok)
+
+	// nodes outside of the subtree don't count
+	ok, err = FindOne(d, "//book").Has("ancestor::r")
+	testTrue(t, err == nil && !ok)
+	ok, err = book.Has("following-sibling::book")
+	testTrue(t, err == nil && !ok)
+
+	ok, err = book.Has(".//book")
+	testTrue(t, err == nil && !ok)
+
+	// the subtree of the book is its own document
+	ok, err = book.Has("//book[@id='bk101']")
+	testTrue(t, err == nil && !ok)
+
+	_, err = book.Has("author[")
+	testTrue(t, err != nil)
+}
+
+func TestRenameAll(t *testing.T) {
+	doc := loadXML(`<a xmlns:n="urn:n"><foo>1</foo><b><foo/></b><foo x="1"/></a>`)
+	count, err := RenameAll(doc, "//foo | //foo/@x | //foo/text()", "n:bar")
+	testTrue(t, err == nil)
+	testValue(t, count, 3)
+	testValue(t, doc.OutputXML(false), `<?xml version="1.0"?><a xmlns:n="urn:n"><n:bar>1</n:bar><b><n:bar></n:bar></b><n:bar x="1"></n:bar></a>`)
+	testValue(t, len(Find(doc, "//*[namespace-uri()='urn:n']")), 3)
+
+	_, err = RenameAll(doc, "//foo[", "bar")
+	testTrue(t, err != nil)
+}
+
+func TestQueryAllUnique(t *testing.T) {
+	// each @id is selected once per child element of its book
+	testValue(t, len(QuerySelectorAllWith(doc, xpath.MustCompile("//book/*/../@id"), QueryOptions{})), 18)
+	nodes, err := QueryAllUnique(doc, "//book/*/../@id", nil)
+	testTrue(t, err == nil)
+	testValue(t, len(nodes), 3)
+	testValue(t, nodes[2].InnerText(), "bk103")
+
+	nodes, err = QueryAllUnique(doc, "//book/genre", (*Node).InnerText)
+	testTrue(t, err == nil)
+	testValue(t, len(nodes), 2)
+	testValue(t, nodes[0].InnerText(), "Computer")
+	testValue(t, nodes[1].InnerText(), "Fantasy")
+
+	nodes, err = QueryAllUnique(doc, "//book/@id | //book/title", (*Node).XPath)
+	testTrue(t, err == nil)
+	testValue(t, len(nodes), 6)
+
+	_, err = QueryAllUnique(doc, "//book[", nil)
+	testTrue(t, err != nil)
+}
+
+func TestCreateXPathNavigatorWithRoot(t *testing.T) {
+	book := FindOne(doc, "//book[@id='bk102']")
+	selectAll := func(nav *NodeNavigator, expr string) []*Node {
+		var list []*Node
+		iter := xpath.MustCompile(expr).Select(nav)
+		for iter.MoveNext() {
+			list = append(list, getCurrentNode(iter))
+		}
+		return list
+	}
+	testValue(t, len(selectAll(CreateXPathNavigatorWithRoot(nil, book), "/catalog/book")), 3)
+	testValue(t, len(selectAll(CreateXPathNavigatorWithRoot(doc, book), "//book")), 3)
+	testValue(t, selectAll(CreateXPathNavigatorWithRoot(nil, book), "author")[0].InnerText(), "Ralls, Kim")
+	testValue(t, len(selectAll(CreateXPathNavigator(book), "/catalog/book")), 0)
+	testValue(t, xpath.MustCompile("string(/catalog/book[1]/@id)").Evaluate(CreateXPathNavigatorWithRoot(nil, book)), "bk101")
+}
+
+func TestQueryMap(t *testing.T) {
+	ids, err := QueryMap(doc, "//book", func(n *Node) string { return n.SelectAttr("id") })
+	testTrue(t, err == nil)
+	testTrue(t, reflect.DeepEqual(ids, []string{"bk101", "bk102", "bk103"}))
+
+	ids, err = QueryMap(doc, "//book[@id='none']", (*Node).InnerText)
+	testTrue(t, err == nil && ids == nil)
+
+	_, err = QueryMap(doc, "//book[", (*Node).InnerText)
+	testTrue(t, err != nil)
+}
+
+func TestSelector(t *testing.T) {
+	s, err := NewSelector("//book[genre='Fantasy']")
+	testTrue(t, err == nil)
+	testValue(t, s.String(), "//book[genre='Fantasy']")
+	testValue(t, len(s.Find(doc)), 2)
+	testValue(t, s.FindOne(doc).SelectAttr("id"), "bk102")
+	testTrue(t, MustCompile("//magazine").FindOne(doc) == nil)
+
+	_, err = NewSelector("//book[")
+	qe, ok := err.(*QueryError)
+	testTrue(t, ok && qe.Expr == "//book[")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MustCompile should panic for an invalid expression")
+		}
+	}()
+	MustCompile("//book[")
+}
+
+func TestSelectAttrWithNS(t *testing.T) {
+	doc := loadXML(`<a xmlns:xlink="http://www.w3.org/1999/xlink" xlink:href="#x" href="" title="t"/>`)
+	n := FindOne(doc, "/a")
+	value, ns, ok := n.SelectAttrWithNS("xlink:href")
+	testTrue(t, ok)
+	testValue(t, value, "#x")
+	testValue(t, ns, "http://www.w3.org/1999/xlink")
+
+	value, ns, ok = n.SelectAttrWithNS("href")
+	testTrue(t, ok && value == "" && ns == "")
+
+	_, _, ok = n.SelectAttrWithNS("missing")
+	testTrue(t, !ok)
+
+	value, _, ok = FindOne(doc, "/a/@title").SelectAttrWithNS("title")
+	testTrue(t, ok && value == "t")
+}
+
+func TestSelectPath(t *testing.T) {
+	doc := loadXML(`<rss xmlns:dc="http://purl.org/dc/elements/1.1/"><channel><title>c</title><item><title>first</title><dc:creator>me</dc:creator></item><item><title>second</title></item></channel></rss>`)
+	testValue(t, doc.SelectPath("rss", "channel", "item", "title").InnerText(), "first")
+	testValue(t, doc.SelectPath("rss", "channel", "item", "dc:creator").InnerText(), "me")
+	testValue(t, doc.SelectPath("rss", "channel", "item", "creator").InnerText(), "me")
+	testTrue(t, doc.SelectPath("rss", "channel", "missing", "title") == nil)
+	testTrue(t, doc.SelectPath("rss", "item") == nil)
+	testTrue(t, doc.SelectPath() == doc)
+}
+
+func TestTextOf(t *testing.T) {
+	book := FindOne(doc, "//book[@id='bk101']")
+	m := book.TextOf("author", "title", "isbn", "author")
+	testTrue(t, reflect.DeepEqual(m, map[string]string{"author": "Gambardella, Matthew", "title": "XML Developer's Guide", "isbn": ""}))
+	testValue(t, len(book.TextOf()), 0)
+}
+
+func TestSelectElementsRange(t *testing.T) {
+	doc := loadXML(`<list><item>0</item><other/><item>1</item><item>2</item><item>3</item><item>4</item></list>`)
+	list := FindOne(doc, "/list")
+	texts := func(nodes []*Node) []string {
+		var s []string
+		for _, n := range nodes {
+			s = append(s, n.InnerText())
+		}
+		return s
+	}
+	testTrue(t, reflect.DeepEqual(texts(list.SelectElementsRange("item", 1, 3)), []string{"1", "2"}))
+	testTrue(t, reflect.DeepEqual(texts(list.SelectElementsRange("item", 3, 10)), []string{"3", "4"}))
+	testTrue(t, reflect.DeepEqual(texts(list.SelectElementsRange("item", -1, 1)), []string{"0"}))
+	testTrue(t, list.SelectElementsRange("item", 5, 10) == nil)
+	testTrue(t, list.SelectElementsRange("item", 2, 2) == nil)
+}
+
+func TestSelectAllAttr(t *testing.T) {
+	doc := loadXML(`<a id="1" x:id="2" id="3" xmlns:x="urn:x"/>`)
+	n := FindOne(doc, "/a")
+	testValue(t, n.SelectAttr("id"), "1")
+	testTrue(t, reflect.DeepEqual(n.SelectAllAttr("id"), []string{"1", "3"}))
+	testTrue(t, reflect.DeepEqual(n.SelectAllAttr("x:id"), []string{"2"}))
+	testTrue(t, n.SelectAllAttr("missing") == nil)
+	testTrue(t, reflect.DeepEqual(FindOne(doc, "/a/@x:id").SelectAllAttr("id"), []string{"2"}))
+}
+
+func TestAttrValues(t *testing.T) {
+	doc := loadXML(`<p><a href="/1">x</a><a>y</a><a href="">z</a><a href="/4"/></p>`)
+	links := Find(doc, "//a")
+	testTrue(t, reflect.DeepEqual(AttrValues(links, "href"), []string{"/1", "", "", "/4"}))
+	testTrue(t, reflect.DeepEqual(PresentAttrValues(links, "href"), []string{"/1", "", "/4"}))
+	testTrue(t, len(AttrValues(nil, "href")) == 0)
+	testTrue(t, PresentAttrValues(links, "title") == nil)
+}
+
+func TestAttrsInNamespace(t *testing.T) {
+	doc := loadXML(`<a xmlns="urn:default" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcx="http://purl.org/dc/elements/1.1/" dc:title="T" id="1" dcx:creator="C" dc:date=""/>`)
+	n := FindOne(doc, "/*")
+	list := n.AttrsInNamespace("http://purl.org/dc/elements/1.1/")
+	testValue(t, len(list), 3)
+	testValue(t, list[0].Name.Local, "title")
+	testValue(t, list[1].Name.Space, "dcx")
+	testValue(t, list[1].Value, "C")
+	testValue(t, list[2].Name.Local, "date")
+
+	list = n.AttrsInNamespace("")
+	testValue(t, len(list), 1)
+	testValue(t, list[0].Name.Local, "id")
+	testValue(t, len(n.AttrsInNamespace("urn:other")), 0)
+}
+
+func TestAttrsInNamespaceWithOptions(t *testing.T) {
+	doc := loadXML(`<a xmlns:x="http://example.com/x/" xmlns:y="HTTP://EXAMPLE.com/x" x:p="1" y:q="2" r="3"/>`)
+	n := FindOne(doc, "/a")
+	testValue(t, len(n.AttrsInNamespace("http://example.com/x")), 0)
+	list := n.AttrsInNamespaceWithOptions("http://example.com/x", NSMatchOptions{IgnoreTrailingSlash: true, IgnoreSchemeHostCase: true})
+	testValue(t, len(list), 2)
+	testValue(t, list[0].Value, "1")
+	testValue(t, list[1].Value, "2")
+}
+
+func TestAttributeNodeIdentity(t *testing.T) {
+	doc := loadXML(`<a xmlns:x="urn:x"><b id="1" x:ref="r"/></a>`)
+	n1 := FindOne(doc, "//@id")
+	n2 := FindOne(doc, "//b/@id")
+	testTrue(t, SameNode(n1, n2))
+	testTrue(t, !SameNode(n1, FindOne(doc, "//@x:ref")))
+	testTrue(t, LCA(n1, n2) == n1)
+	testTrue(t, n1.Parent == FindOne(doc, "//b"))
+	testTrue(t, n1.FirstChild.Parent == n1)
+	testValue(t, n1.InnerText(), "1")
+
+	ref := FindOne(doc, "//@x:ref")
+	testValue(t, ref.Data, "ref")
+	testValue(t, ref.Prefix, "x")
+	testValue(t, ref.NamespaceURI, "urn:x")
+	testTrue(t, SameNode(ResolvePath(doc, "/a/b/@x:ref"), ref))
+	testValue(t, ref.XPath(), "/a/b/@x:ref")
+
+	// a removed attribute is not returned again
+	FindOne(doc, "//b").RemoveAttr("id")
+	testTrue(t, FindOne(doc, "//@id") == nil)
+}
+
+func TestQueryError(t *testing.T) {
+	for _, disable := range []bool{false, true} {
+		DisableSelectorCache = disable
+		_, err := QueryAll(doc, "//book[")
+		var queryErr *QueryError
+		testTrue(t, errors.As(err, &queryErr))
+		testValue(t, queryErr.Expr, "//book[")
+		testTrue(t, queryErr.Unwrap() != nil)
+		testTrue(t, strings.HasPrefix(err.Error(), "xmlquery: invalid expression '//book['"))
+
+		_, err = Query(doc, "//book[")
+		testTrue(t, errors.As(err, &queryErr))
+		err = QueryAllEach(doc, "//book[", func(*Node) bool { return true })
+		testTrue(t, errors.As(err, &queryErr))
+	}
+	DisableSelectorCache = false
+}
@@ -0,0 +1,132 @@
+package xmlquery
+
+import (
+	"bufio"
+	"io"
+)
+
+// StreamTransform reads the XML document from r and writes it to w,
+// replacing each element matching elementXPath by the node returned by
+// transform, or removing it if transform returns nil. The elements are
+// streamed as with CreateStreamParser, so the whole document is never held
+// in memory, and transform may modify the element it's given and return
+// it. The content surrounding the matching elements is written as it is,
+// except that an XML declaration is added if the document has none.
+//
+// The processing stops at the first error returned by transform.
+func StreamTransform(r io.Reader, w io.Writer, elementXPath string, transform func(*Node) (*Node, error)) error {
+	sp, err := CreateStreamParser(r, elementXPath)
+	if err != nil {
+		return err
+	}
+	st := &streamTransformer{w: bufio.NewWriter(w)}
+	for {
+		n, err := sp.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err = st.writeBefore(n); err != nil {
+			return err
+		}
+		result, err := transform(n)
+		if err != nil {
+			return err
+		}
+		if result != nil {
+			if err = result.WriteWithOptions(st.w, WithOutputSelf()); err != nil {
+				return err
+			}
+		}
+	}
+	if err = st.writeRest(sp.p.doc, 0); err != nil {
+		return err
+	}
+	return st.w.Flush()
+}
+
+type streamTransformer struct {
+	w *bufio.Writer
+	// The elements whose start tag is written but not the end tag yet,
+	// from the document element down.
+	opened []*Node
+}
+
+// writeBefore writes the content of the document preceding n that isn't
+// written yet, and removes it from the tree, except for the ancestors of n
+// whose start tags are written instead.
+func (st *streamTransformer) writeBefore(n *Node) error {
+	var chain []*Node
+	for a := n.Parent; a != nil && a.Type != DocumentNode; a = a.Parent {
+		chain = append(chain, a)
+	}
+	parent := n
+	for parent.Parent != nil {
+		parent = parent.Parent
+	}
+	for depth := 0; ; depth++ {
+		next := n
+		if i := len(chain) - 1 - depth; i >= 0 {
+			next = chain[i]
+		}
+		for child := parent.FirstChild; child != next; {
+			sibling := child.NextSibling
+			if err := st.writeCompleted(child, depth); err != nil {
+				return err
+			}
+			RemoveFromTree(child)
+			child = sibling
+		}
+		if next == n {
+			return nil
+		}
+		if depth >= len(st.opened) || st.opened[depth] != next {
+			st.opened = append(st.opened[:depth], next)
+			if err := st.writeStartTag(next); err != nil {
+				return err
+			}
+		}
+		parent = next
+	}
+}
+
+// writeCompleted writes n, a node of the tree at depth whose end is read.
+// If n is an opened element, only the remaining content and the end tag
+// are written.
+func (st *streamTransformer) writeCompleted(n *Node, depth int) error {
+	if depth >= len(st.opened) || st.opened[depth] != n {
+		return n.WriteWithOptions(st.w, WithOutputSelf())
+	}
+	if err := st.writeRest(n, depth+1); err != nil {
+		return err
+	}
+	st.opened = st.opened[:depth]
+	_, err := st.w.WriteString("</" + n.qualifiedName() + ">")
+	return err
+}
+
+// writeRest writes the children of n, whose depth is the depth of the
+// children in the tree.
+func (st *streamTransformer) writeRest(n *Node, depth int) error {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if err := st.writeCompleted(child, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *streamTransformer) writeStartTag(n *Node) error {
+	st.w.WriteString("<" + n.qualifiedName())
+	for _, attr := range n.Attr {
+		name := attr.Name.Local
+		if attr.Name.Space != "" {
+			name = attr.Name.Space + ":" + name
+		}
+		st.w.WriteString(" " + name + `="` + EscapeAttr(attr.Value) + `"`)
+	}
+	_, err := st.w.WriteString(">")
+	return err
+}
@@ -0,0 +1,62 @@
+package xmlquery
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestStreamTransform(t *testing.T) {
+	s := `<?xml version="1.0"?>
+<!-- orders -->
+<root xmlns:x="urn:x" a="&amp;">
+	<header>h</header>
+	<group id="1">
+		<item n="1">one</item>
+		<item n="2">two</item>
+		<note/>
+	</group>
+	<group id="2"><item n="3">three</item></group>
+	<x:footer>f</x:footer>
+</root>`
+	var b bytes.Buffer
+	err := StreamTransform(strings.NewReader(s), &b, "//item", func(n *Node) (*Node, error) {
+		if n.SelectAttr("n") == "2" {
+			return nil, nil
+		}
+		n.SetAttr("seen", "yes")
+		n.FirstChild.Data = strings.ToUpper(n.FirstChild.Data)
+		return n, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0"?>
+<!-- orders -->
+<root xmlns:x="urn:x" a="&amp;">
+	<header>h</header>
+	<group id="1">
+		<item n="1" seen="yes">ONE</item>
+		
+		<note></note>
+	</group>
+	<group id="2"><item n="3" seen="yes">THREE</item></group>
+	<x:footer>f</x:footer>
+</root>`
+	testValue(t, b.String(), want)
+}
+
+func TestStreamTransformError(t *testing.T) {
+	var b bytes.Buffer
+	errStop := errors.New("stop")
+	err := StreamTransform(strings.NewReader(`<a><b/></a>`), &b, "//b", func(n *Node) (*Node, error) {
+		return nil, errStop
+	})
+	testTrue(t, err == errStop)
+
+	err = StreamTransform(strings.NewReader(`<a/>`), &b, "//b[", func(n *Node) (*Node, error) {
+		return n, nil
+	})
+	testTrue(t, err != nil)
+}
@@ -0,0 +1,194 @@
+package xmlquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// XIncludeNamespace is the namespace URI of XInclude elements.
+const XIncludeNamespace = "http://www.w3.org/2001/XInclude"
+
+// ResolveXIncludes replaces the xi:include elements found in the tree
+// rooted at n by the content they reference. The loader is called with
+// the href attribute of each include element and returns the referenced
+// document; includes in the loaded documents are resolved as well.
+//
+// With parse="text", the include element is replaced by a text node
+// holding the InnerText of the loaded node, so the loader may return a
+// single TextNode for resources that aren't XML. With parse="xml", the
+// default, the xpointer attribute may select part of the loaded document
+// using a shorthand ID, the element() scheme or the xpointer() scheme
+// with an XPath expression.
+//
+// If the loader fails and the include element has an xi:fallback child,
+// the content of the fallback is used instead.
+func ResolveXIncludes(n *Node, loader func(href string) (*Node, error)) error {
+	return resolveXIncludes(n, loader, nil)
+}
+
+func resolveXIncludes(n *Node, loader func(href string) (*Node, error), hrefs []string) error {
+	var includes []*Node
+	var walk func(*Node)
+	walk = func(n *Node) {
+		if isXInclude(n, "include") {
+			includes = append(includes, n)
+			return
+		}
+		for child := n.FirstChild; child != nil; child = child.NextSibling {
+			walk(child)
+		}
+	}
+	walk(n)
+
+	for _, include := range includes {
+		if include.Parent == nil {
+			return fmt.Errorf("xmlquery: cannot replace the root xi:include element")
+		}
+		content, err := loadXInclude(include, loader, hrefs)
+		if err != nil {
+			fallback := xincludeFallback(include)
+			if fallback == nil {
+				return err
+			}
+			content = nil
+			for child := fallback.FirstChild; child != nil; child = child.NextSibling {
+				content = append(content, child)
+			}
+		}
+		for child := include.FirstChild; child != nil; {
+			next := child.NextSibling
+			child.Parent, child.PrevSibling, child.NextSibling = nil, nil, nil
+			child = next
+		}
+		include.FirstChild, include.LastChild = nil, nil
+		for _, c := range content {
+			AddChild(include, cloneNode(c, include.level+1))
+		}
+		include.Unwrap()
+	}
+	return nil
+}
+
+func loadXInclude(include *Node, loader func(href string) (*Node, error), hrefs []string) ([]*Node, error) {
+	href := include.SelectAttr("href")
+	for _, h := range hrefs {
+		if h == href {
+			return nil, fmt.Errorf("xmlquery: recursive xi:include of '%s'", href)
+		}
+	}
+	doc, err := loader(href)
+	if err != nil {
+		return nil, err
+	}
+	switch parse := include.SelectAttr("parse"); parse {
+	case "text":
+		return []*Node{{Type: TextNode, Data: doc.InnerText()}}, nil
+	case "", "xml":
+	default:
+		return nil, fmt.Errorf("xmlquery: invalid xi:include parse attribute '%s'", parse)
+	}
+	if err := resolveXIncludes(doc, loader, append(hrefs, href)); err != nil {
+		return nil, err
+	}
+	if xpointer := include.SelectAttr("xpointer"); xpointer != "" {
+		return selectXPointer(doc, xpointer)
+	}
+	if doc.Type != DocumentNode {
+		return []*Node{doc}, nil
+	}
+	var content []*Node
+	for child := doc.FirstChild; child != nil; child = child.NextSibling {
+		switch {
+		case child.Type == DeclarationNode && child.Data == "xml",
+			child.Type == TextNode && strings.TrimSpace(child.Data) == "":
+			continue
+		}
+		content = append(content, child)
+	}
+	return content, nil
+}
+
+// selectXPointer returns the nodes of doc selected by the shorthand,
+// element() or xpointer() form of an XPointer.
+func selectXPointer(doc *Node, xpointer string) ([]*Node, error) {
+	var list []*Node
+	switch {
+	case strings.HasPrefix(xpointer, "xpointer(") && strings.HasSuffix(xpointer, ")"):
+		var err error
+		list, err = QueryAll(doc, xpointer[len("xpointer("):len(xpointer)-1])
+		if err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(xpointer, "element(") && strings.HasSuffix(xpointer, ")"):
+		if n := selectChildSequence(doc, xpointer[len("element("):len(xpointer)-1]); n != nil {
+			list = []*Node{n}
+		}
+	case !strings.ContainsAny(xpointer, "()/"):
+		if n := elementByID(doc, xpointer); n != nil {
+			list = []*Node{n}
+		}
+	default:
+		return nil, fmt.Errorf("xmlquery: unsupported xpointer '%s'", xpointer)
+	}
+	if len(list) == 0 {
+		return nil, fmt.Errorf("xmlquery: xpointer '%s' selects nothing", xpointer)
+	}
+	return list, nil
+}
+
+// selectChildSequence returns the element selected by the element()
+// scheme pointer, such as "intro/2/1" or "/1/3".
+func selectChildSequence(doc *Node, pointer string) *Node {
+	steps := strings.Split(pointer, "/")
+	n := doc
+	if steps[0] != "" {
+		if n = elementByID(doc, steps[0]); n == nil {
+			return nil
+		}
+	}
+	for _, step := range steps[1:] {
+		i, err := strconv.Atoi(step)
+		if err != nil || i < 1 {
+			return nil
+		}
+		if n = n.ChildElementAt(i - 1); n == nil {
+			return nil
+		}
+	}
+	if n == doc {
+		return nil
+	}
+	return n
+}
+
+// elementByID returns the first element whose xml:id or id attribute is id.
+func elementByID(n *Node, id string) *Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if child.Type != ElementNode {
+			continue
+		}
+		for _, attr := range child.Attr {
+			if attr.Name.Local == "id" && (attr.Name.Space == "" || attr.Name.Space == "xml") && attr.Value == id {
+				return child
+			}
+		}
+		if e := elementByID(child, id); e != nil {
+			return e
+		}
+	}
+	return nil
+}
+
+func isXInclude(n *Node, local string) bool {
+	return n.Type == ElementNode && n.Data == local && n.NamespaceURI == XIncludeNamespace
+}
+
+func xincludeFallback(include *Node) *Node {
+	for child := include.FirstChild; child != nil; child = child.NextSibling {
+		if isXInclude(child, "fallback") {
+			return child
+		}
+	}
+	return nil
+}
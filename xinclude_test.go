@@ -0,0 +1,54 @@
+package xmlquery
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestResolveXIncludes(t *testing.T) {
+	files := map[string]string{
+		"chapter.xml": `<?xml version="1.0"?><chapter xml:id="c1"><title>One</title><p>first</p><p id="p2">second</p></chapter>`,
+		"nested.xml":  `<part xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="chapter.xml" xpointer="p2"/></part>`,
+		"loop.xml":    `<loop xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="loop.xml"/></loop>`,
+	}
+	loader := func(href string) (*Node, error) {
+		if href == "notes.txt" {
+			return &Node{Type: TextNode, Data: "a < b"}, nil
+		}
+		s, ok := files[href]
+		if !ok {
+			return nil, errors.New("not found: " + href)
+		}
+		return Parse(strings.NewReader(s))
+	}
+
+	doc := loadXML(`<book xmlns:xi="http://www.w3.org/2001/XInclude">` +
+		`<xi:include href="chapter.xml"/>` +
+		`<xi:include href="chapter.xml" xpointer="element(c1/2)"/>` +
+		`<xi:include href="chapter.xml" xpointer="xpointer(//p)"/>` +
+		`<xi:include href="nested.xml"/>` +
+		`<note><xi:include href="notes.txt" parse="text"/></note>` +
+		`<xi:include href="missing.xml"><xi:fallback><p>none</p></xi:fallback></xi:include>` +
+		`</book>`)
+	testTrue(t, ResolveXIncludes(doc, loader) == nil)
+	testValue(t, FindOne(doc, "/book").OutputXML(true), `<book xmlns:xi="http://www.w3.org/2001/XInclude">`+
+		`<chapter xml:id="c1"><title>One</title><p>first</p><p id="p2">second</p></chapter>`+
+		`<p>first</p>`+
+		`<p>first</p><p id="p2">second</p>`+
+		`<part xmlns:xi="http://www.w3.org/2001/XInclude"><p id="p2">second</p></part>`+
+		`<note>a &lt; b</note>`+
+		`<p>none</p>`+
+		`</book>`)
+	verifyNodePointers(t, doc)
+	testValue(t, FindOne(doc, "//part/p").Level(), 3)
+
+	for _, s := range []string{
+		`<a xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="missing.xml"/></a>`,
+		`<a xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="loop.xml"/></a>`,
+		`<a xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="chapter.xml" xpointer="nothing"/></a>`,
+		`<a xmlns:xi="http://www.w3.org/2001/XInclude"><xi:include href="chapter.xml" parse="binary"/></a>`,
+	} {
+		testTrue(t, ResolveXIncludes(loadXML(s), loader) != nil)
+	}
+}